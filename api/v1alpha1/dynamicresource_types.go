@@ -19,6 +19,8 @@ package v1alpha1
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -30,45 +32,414 @@ type DynamicResourceSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// Target resource definition
+	// Mutually exclusive with Targets
 	// +kubebuilder:validation:EmbeddedResource
 	// +kubebuilder:pruning:PreserveUnknownFields
-	// +kubebuilder:validation:Required
-	Target unstructured.Unstructured `json:"target"`
+	// +kubebuilder:validation:Optional
+	Target *unstructured.Unstructured `json:"target,omitempty"`
+
+	// Targets are multiple target resource definitions, that are rendered from the same sources
+	// Mutually exclusive with Target
+	// +kubebuilder:validation:Optional
+	Targets []TargetObject `json:"targets,omitempty"`
 
 	// +kubebuilder:validation:Optional
 	Transformations []DynamicResourceTransformation `json:"transformations"`
+
+	// ApplyStrategy defines how the rendered target is written to the cluster
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Update
+	ApplyStrategy ApplyStrategy `json:"applyStrategy,omitempty"`
+
+	// ForceConflicts takes ownership of fields that are managed by other field managers
+	// Only applies to the ServerSideApply strategy
+	// +kubebuilder:validation:Optional
+	ForceConflicts bool `json:"forceConflicts,omitempty"`
+
+	// Prune deletes targets that were applied before, but are no longer rendered, e.g. after a target was renamed
+	// Individual targets are kept if they are annotated with dynamic.kube/prune: disabled
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	Prune *bool `json:"prune,omitempty"`
+
+	// DeletionPolicy defines what happens to the targets when the DynamicResource is deleted
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// AdoptionPolicy defines whether targets that already exist and were not created by this DynamicResource are taken over
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=IfUnowned
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// ResyncInterval periodically re-renders the target in addition to reacting to changes of source objects
+	// +kubebuilder:validation:Optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount in the namespace of the DynamicResource that is impersonated
+	// to read the sources and write the targets, so that they are authorized with its permissions
+	// +kubebuilder:validation:Optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// TargetObject is a target resource definition
+// +kubebuilder:validation:XEmbeddedResource
+// +kubebuilder:pruning:PreserveUnknownFields
+type TargetObject struct {
+	unstructured.Unstructured `json:",inline"`
 }
 
+const (
+	// PruneAnnotation on a target object disables pruning of it if set to PruneDisabled
+	PruneAnnotation = "dynamic.kube/prune"
+
+	// PruneDisabled is the value of PruneAnnotation that disables pruning
+	PruneDisabled = "disabled"
+)
+
+const (
+	// OwnerNamespaceLabel records the namespace of the controlling DynamicResource on targets that cannot
+	// carry an owner reference to it, i.e. cluster-scoped targets and targets in another namespace
+	OwnerNamespaceLabel = "dynamic.kube/owner-namespace"
+
+	// OwnerUIDLabel records the UID of the controlling DynamicResource, see OwnerNamespaceLabel
+	OwnerUIDLabel = "dynamic.kube/owner-uid"
+
+	// OwnerAnnotation records the DynamicResource that rendered a target as <namespace>/<name>.
+	// Names may exceed the length of label values, so the name is only recorded here.
+	OwnerAnnotation = "dynamic.kube/owned-by"
+)
+
+// AdoptionPolicy describes how targets are handled that already exist, but are not controlled by the DynamicResource
+// +kubebuilder:validation:Enum=Never;IfUnowned;Always
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever refuses to write to any existing target that is not controlled by the DynamicResource
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+
+	// AdoptionPolicyIfUnowned adopts existing targets that are not controlled by anyone else
+	AdoptionPolicyIfUnowned AdoptionPolicy = "IfUnowned"
+
+	// AdoptionPolicyAlways adopts existing targets and takes over control from their current owner
+	AdoptionPolicyAlways AdoptionPolicy = "Always"
+)
+
+// DeletionPolicy describes what happens to the targets when the DynamicResource is deleted
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the targets
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+
+	// DeletionPolicyOrphan keeps the targets and removes all references to the DynamicResource from them
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+
+	// DeletionPolicyRetain keeps the targets as they are, only the owner reference is removed,
+	// so that they are not garbage-collected
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// ApplyStrategy describes how the rendered target is written to the cluster
+// +kubebuilder:validation:Enum=Update;ServerSideApply
+type ApplyStrategy string
+
+const (
+	// ApplyStrategyUpdate replaces the whole target object
+	ApplyStrategyUpdate ApplyStrategy = "Update"
+
+	// ApplyStrategyServerSideApply only owns the fields that are rendered by the controller
+	ApplyStrategyServerSideApply ApplyStrategy = "ServerSideApply"
+)
+
+// DynamicResourceTransformation injects a value into the target
+// The value is either read by fieldFrom or rendered by template from named sources
 type DynamicResourceTransformation struct {
-	FieldFrom ExternalFieldRef `json:"fieldFrom"`
+	// Target addresses the target to inject the value into by its index or name in targets
+	// Defaults to the first target
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:XIntOrString
+	Target *intstr.IntOrString `json:"target,omitempty"`
+
+	// FieldFrom references the field to copy the value from
+	// +kubebuilder:validation:Optional
+	FieldFrom *ExternalFieldRef `json:"fieldFrom,omitempty"`
+
+	// Sources are named references to fields, that are available in template, e.g. as {{ .password }},
+	// and as variables in cel, e.g. as password
+	// +kubebuilder:validation:Optional
+	Sources map[string]ExternalFieldRef `json:"sources,omitempty"`
+
+	// Template is a Go text/template rendering the value from the named sources
+	// Available functions: b64enc, b64dec, default, upper, trim, toJson, sha256sum, indent
+	// +kubebuilder:validation:Optional
+	Template string `json:"template,omitempty"`
+
+	// CEL is a Common Expression Language expression computing the value from the named sources,
+	// e.g. "int(replicas) * 2". Mutually exclusive with fieldFrom and template
+	// docs: https://github.com/google/cel-spec/blob/master/doc/langdef.md
+	// +kubebuilder:validation:Optional
+	CEL string `json:"cel,omitempty"`
 
 	// TargetField is the field where the value shall be injected
-	// Todo: Add more advanced field matchers (that accept e.g. arrays, etc)
 	// dot-delimited
-	TargetField string `json:"targetField"`
+	// +kubebuilder:validation:Optional
+	TargetField string `json:"targetField,omitempty"`
+
+	// TargetPath is the field where the value shall be injected as RFC 6901 JSON Pointer, e.g. "/data/tls.crt"
+	// Items of lists of named objects can be selected with "key[field=value]", e.g. "/spec/containers[name=app]/image"
+	// Mutually exclusive with TargetField
+	// +kubebuilder:validation:Optional
+	TargetPath string `json:"targetPath,omitempty"`
+
+	// ValueType defines the type of the injected value
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=String
+	ValueType ValueType `json:"valueType,omitempty"`
+
+	// Encoding defines whether the value is base64 encoded or decoded before it is injected
+	// Auto decodes values read from the data of a Secret and encodes values written to the data of a Secret,
+	// unless the value is copied from the data of a Secret to the data of a Secret
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Auto
+	Encoding Encoding `json:"encoding,omitempty"`
 }
 
+// Encoding describes how a value is encoded before it is injected
+// +kubebuilder:validation:Enum=Auto;None;Base64Decode;Base64Encode
+type Encoding string
+
+const (
+	// EncodingAuto detects the encoding from the source and target fields
+	EncodingAuto Encoding = "Auto"
+
+	// EncodingNone injects the value as is
+	EncodingNone Encoding = "None"
+
+	// EncodingBase64Decode decodes a base64 encoded source value
+	EncodingBase64Decode Encoding = "Base64Decode"
+
+	// EncodingBase64Encode base64 encodes the value
+	EncodingBase64Encode Encoding = "Base64Encode"
+)
+
+// ValueType describes how a value read from a source is converted before it is injected
+// +kubebuilder:validation:Enum=Auto;String;Int;Bool;Object;JSON
+type ValueType string
+
+const (
+	// ValueTypeAuto injects the value with the type it has in the source object
+	ValueTypeAuto ValueType = "Auto"
+
+	// ValueTypeString renders the value as a string, like kubectl does for JSONPath output
+	ValueTypeString ValueType = "String"
+
+	// ValueTypeInt injects an integer, strings are parsed
+	ValueTypeInt ValueType = "Int"
+
+	// ValueTypeBool injects a boolean, strings are parsed
+	ValueTypeBool ValueType = "Bool"
+
+	// ValueTypeObject injects a map or list
+	ValueTypeObject ValueType = "Object"
+
+	// ValueTypeJSON parses a string containing a JSON document and injects the decoded value
+	ValueTypeJSON ValueType = "JSON"
+)
+
 // ExternalFieldRef Reference to a field of any resource on the cluster
+// The source resource is either referenced by name or matched by selector and/or fieldSelector
 type ExternalFieldRef struct {
 	metav1.TypeMeta `json:",inline"`
 
-	// Todo: Add more advanced resource matchers, e.g. label- and field-based matching
-	// Name of the target resource
-	Name string `json:"name"`
+	// Name of the source resource
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace of the source resource, defaults to the namespace of the DynamicResource
+	// Reading from other namespaces needs to be allowed by the controller, cluster-scoped kinds ignore it
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Selector matches source resources by their labels
+	// +kubebuilder:validation:Optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// FieldSelector matches source resources by their fields, e.g. "type=kubernetes.io/tls"
+	// +kubebuilder:validation:Optional
+	FieldSelector string `json:"fieldSelector,omitempty"`
+
+	// Pick defines how multiple resources matched by selector or fieldSelector are handled
+	// Required if selector or fieldSelector is set
+	// +kubebuilder:validation:Optional
+	Pick PickPolicy `json:"pick,omitempty"`
 
 	// FieldSpec JSONPath selector for the field to copy the data from
 	// docs: https://kubernetes.io/docs/reference/kubectl/jsonpath/
 	FieldSpec string `json:"fieldSpec"`
+
+	// ValueType defines the type of the value read from a source of a template or cel transformation, defaults to Auto
+	// Variables of cel expressions are declared with the corresponding type, lists of it if all matches are picked
+	// Not supported for fieldFrom, which uses the valueType of the transformation
+	// +kubebuilder:validation:Optional
+	ValueType ValueType `json:"valueType,omitempty"`
+
+	// Sensitive marks the values read from the source as confidential, so that they are redacted from
+	// logs, events and the status. Values read from Secrets are always treated as sensitive.
+	// +kubebuilder:validation:Optional
+	Sensitive bool `json:"sensitive,omitempty"`
 }
 
+// PickPolicy describes how multiple source resources matched by a selector are handled
+// +kubebuilder:validation:Enum=Single;Newest;Oldest;All
+type PickPolicy string
+
+const (
+	// PickSingle requires exactly one matching resource
+	PickSingle PickPolicy = "Single"
+
+	// PickNewest uses the most recently created matching resource
+	PickNewest PickPolicy = "Newest"
+
+	// PickOldest uses the least recently created matching resource
+	PickOldest PickPolicy = "Oldest"
+
+	// PickAll uses all matching resources, ordered by name. The values are injected as a list.
+	PickAll PickPolicy = "All"
+)
+
 // DynamicResourceStatus defines the observed state of DynamicResource
 type DynamicResourceStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// ObservedGeneration is the generation of the DynamicResource that was last reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the DynamicResource
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// TargetRef references the first target object that was applied
+	// +optional
+	TargetRef *TargetReference `json:"targetRef,omitempty"`
+
+	// Targets references all target objects that were applied
+	// +optional
+	Targets []TargetReference `json:"targets,omitempty"`
+
+	// LastAppliedTime is the last time the target object was changed by the controller
+	// +optional
+	LastAppliedTime *metav1.Time `json:"lastAppliedTime,omitempty"`
+
+	// LastError is the error message of the last failed reconciliation
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Transformations reports the outcome of each entry of spec.transformations
+	// +optional
+	Transformations []TransformationStatus `json:"transformations,omitempty"`
+}
+
+// TransformationStatus describes the outcome of a single transformation
+type TransformationStatus struct {
+	// Index of the transformation in spec.transformations
+	Index int `json:"index"`
+
+	// Sources references the objects the value was read from
+	// +optional
+	Sources []SourceReference `json:"sources,omitempty"`
+
+	// Resolved indicates whether the FieldSpec yielded a value
+	Resolved bool `json:"resolved"`
+
+	// TargetIndex is the index of the target the value was written to
+	// +optional
+	TargetIndex int `json:"targetIndex,omitempty"`
+
+	// TargetPath is the field of the target the value was written to
+	// +optional
+	TargetPath string `json:"targetPath,omitempty"`
+
+	// Error is the reason why the transformation failed
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
+// SourceReference Reference to the version of a source object that was read by a transformation
+type SourceReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	Name string `json:"name"`
+
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+
+	// +optional
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// TargetReference Reference to a target object written by the controller
+type TargetReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	Name string `json:"name"`
+
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+}
+
+// Condition types of a DynamicResource
+const (
+	// ConditionReady indicates that the target is up to date
+	ConditionReady = "Ready"
+
+	// ConditionSourcesResolved indicates that all transformation sources could be resolved
+	ConditionSourcesResolved = "SourcesResolved"
+
+	// ConditionTargetApplied indicates that the rendered target was written to the cluster
+	ConditionTargetApplied = "TargetApplied"
+
+	// ConditionTargetConflict indicates that an existing target was not adopted because of the adoption policy
+	ConditionTargetConflict = "TargetConflict"
+
+	// ConditionPolicyViolation indicates that the DynamicResource violates a DynamicResourcePolicy
+	ConditionPolicyViolation = "PolicyViolation"
+)
+
+// Condition reasons of a DynamicResource
+const (
+	ReasonReconciled      = "Reconciled"
+	ReasonReconcileFailed = "ReconcileFailed"
+	ReasonResolved        = "Resolved"
+	ReasonResolveFailed   = "ResolveFailed"
+	ReasonApplied         = "Applied"
+	ReasonApplyFailed     = "ApplyFailed"
+	ReasonAdoptionRefused = "AdoptionRefused"
+	ReasonNoConflict      = "NoConflict"
+	ReasonPolicyViolated  = "PolicyViolated"
+	ReasonPolicyCompliant = "PolicyCompliant"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.status.targetRef.name`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // DynamicResource is the Schema for the dynamicresources API
 type DynamicResource struct {
@@ -0,0 +1,41 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhooks of DynamicResource with the manager.
+// The defaulter and validator are implemented by the webhook package of the controller.
+func (r *DynamicResource) SetupWebhookWithManager(mgr ctrl.Manager, defaulter admission.CustomDefaulter, validator admission.CustomValidator) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(defaulter).
+		WithValidator(validator).
+		Complete()
+}
+
+// DefaultResyncInterval is the resync interval of DynamicResources that do not specify one
+const DefaultResyncInterval = 10 * time.Minute
+
+//+kubebuilder:webhook:path=/mutate-dynamic-kube-v1alpha1-dynamicresource,mutating=true,failurePolicy=fail,sideEffects=None,groups=dynamic.kube,resources=dynamicresources,verbs=create;update,versions=v1alpha1,name=mdynamicresource.kb.io,admissionReviewVersions=v1
+
+//+kubebuilder:webhook:path=/validate-dynamic-kube-v1alpha1-dynamicresource,mutating=false,failurePolicy=fail,sideEffects=None,groups=dynamic.kube,resources=dynamicresources,verbs=create;update,versions=v1alpha1,name=vdynamicresource.kb.io,admissionReviewVersions=v1
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// DynamicResourcePolicySpec defines the restrictions for DynamicResources in the selected namespaces
+type DynamicResourcePolicySpec struct {
+	// NamespaceSelector selects the namespaces of the DynamicResources the policy applies to.
+	// The policy applies to all namespaces if it is not set.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllowedSources are the kinds that may be read as sources. All kinds are allowed if it is empty.
+	// +kubebuilder:validation:Optional
+	AllowedSources []PolicyKind `json:"allowedSources,omitempty"`
+
+	// AllowedTargets are the kinds that may be written as targets. All kinds are allowed if it is empty.
+	// +kubebuilder:validation:Optional
+	AllowedTargets []PolicyKind `json:"allowedTargets,omitempty"`
+
+	// AllowCrossNamespace permits sources and targets in other namespaces than the one of the DynamicResource
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	AllowCrossNamespace bool `json:"allowCrossNamespace,omitempty"`
+}
+
+// PolicyKind matches kinds by API group and kind, both accept * as wildcard
+type PolicyKind struct {
+	// Group is the API group of the kind, empty for the core group
+	// +kubebuilder:validation:Optional
+	Group string `json:"group,omitempty"`
+
+	// Kind is the name of the kind
+	Kind string `json:"kind"`
+}
+
+// Matches checks whether the kind matches the group kind
+func (k PolicyKind) Matches(gk schema.GroupKind) bool {
+	return (k.Group == "*" || k.Group == gk.Group) && (k.Kind == "*" || k.Kind == gk.Kind)
+}
+
+// DynamicResourcePolicyStatus defines the observed state of DynamicResourcePolicy
+type DynamicResourcePolicyStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DynamicResourcePolicy is the Schema for the dynamicresourcepolicies API.
+// It restricts the sources and targets of DynamicResources in the selected namespaces.
+type DynamicResourcePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DynamicResourcePolicySpec   `json:"spec,omitempty"`
+	Status DynamicResourcePolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DynamicResourcePolicyList contains a list of DynamicResourcePolicy
+type DynamicResourcePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DynamicResourcePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DynamicResourcePolicy{}, &DynamicResourcePolicyList{})
+}
@@ -22,7 +22,9 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -31,7 +33,7 @@ func (in *DynamicResource) DeepCopyInto(out *DynamicResource) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResource.
@@ -84,14 +86,140 @@ func (in *DynamicResourceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicResourcePolicy) DeepCopyInto(out *DynamicResourcePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourcePolicy.
+func (in *DynamicResourcePolicy) DeepCopy() *DynamicResourcePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicResourcePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicResourcePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicResourcePolicyList) DeepCopyInto(out *DynamicResourcePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DynamicResourcePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourcePolicyList.
+func (in *DynamicResourcePolicyList) DeepCopy() *DynamicResourcePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicResourcePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicResourcePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicResourcePolicySpec) DeepCopyInto(out *DynamicResourcePolicySpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedSources != nil {
+		in, out := &in.AllowedSources, &out.AllowedSources
+		*out = make([]PolicyKind, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedTargets != nil {
+		in, out := &in.AllowedTargets, &out.AllowedTargets
+		*out = make([]PolicyKind, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourcePolicySpec.
+func (in *DynamicResourcePolicySpec) DeepCopy() *DynamicResourcePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicResourcePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicResourcePolicyStatus) DeepCopyInto(out *DynamicResourcePolicyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourcePolicyStatus.
+func (in *DynamicResourcePolicyStatus) DeepCopy() *DynamicResourcePolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicResourcePolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicResourceSpec) DeepCopyInto(out *DynamicResourceSpec) {
 	*out = *in
-	in.Target.DeepCopyInto(&out.Target)
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = (*in).DeepCopy()
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]TargetObject, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Transformations != nil {
 		in, out := &in.Transformations, &out.Transformations
 		*out = make([]DynamicResourceTransformation, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Prune != nil {
+		in, out := &in.Prune, &out.Prune
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ResyncInterval != nil {
+		in, out := &in.ResyncInterval, &out.ResyncInterval
+		*out = new(v1.Duration)
+		**out = **in
 	}
 }
 
@@ -108,6 +236,34 @@ func (in *DynamicResourceSpec) DeepCopy() *DynamicResourceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicResourceStatus) DeepCopyInto(out *DynamicResourceStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TargetRef != nil {
+		in, out := &in.TargetRef, &out.TargetRef
+		*out = new(TargetReference)
+		**out = **in
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]TargetReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastAppliedTime != nil {
+		in, out := &in.LastAppliedTime, &out.LastAppliedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Transformations != nil {
+		in, out := &in.Transformations, &out.Transformations
+		*out = make([]TransformationStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourceStatus.
@@ -123,7 +279,23 @@ func (in *DynamicResourceStatus) DeepCopy() *DynamicResourceStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicResourceTransformation) DeepCopyInto(out *DynamicResourceTransformation) {
 	*out = *in
-	out.FieldFrom = in.FieldFrom
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.FieldFrom != nil {
+		in, out := &in.FieldFrom, &out.FieldFrom
+		*out = new(ExternalFieldRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make(map[string]ExternalFieldRef, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicResourceTransformation.
@@ -140,6 +312,11 @@ func (in *DynamicResourceTransformation) DeepCopy() *DynamicResourceTransformati
 func (in *ExternalFieldRef) DeepCopyInto(out *ExternalFieldRef) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalFieldRef.
@@ -151,3 +328,84 @@ func (in *ExternalFieldRef) DeepCopy() *ExternalFieldRef {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyKind) DeepCopyInto(out *PolicyKind) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyKind.
+func (in *PolicyKind) DeepCopy() *PolicyKind {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyKind)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceReference) DeepCopyInto(out *SourceReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceReference.
+func (in *SourceReference) DeepCopy() *SourceReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetObject) DeepCopyInto(out *TargetObject) {
+	*out = *in
+	in.Unstructured.DeepCopyInto(&out.Unstructured)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetObject.
+func (in *TargetObject) DeepCopy() *TargetObject {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetReference) DeepCopyInto(out *TargetReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetReference.
+func (in *TargetReference) DeepCopy() *TargetReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TransformationStatus) DeepCopyInto(out *TransformationStatus) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]SourceReference, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransformationStatus.
+func (in *TransformationStatus) DeepCopy() *TransformationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TransformationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// adoptionConflict is returned for existing targets that must not be adopted according to the adoption policy
+type adoptionConflict struct {
+	message string
+}
+
+func (e *adoptionConflict) Error() string {
+	return e.message
+}
+
+// isAdoptionConflict checks whether any error in the chain is an adoptionConflict
+func isAdoptionConflict(err error) bool {
+	var conflict *adoptionConflict
+	return errors.As(err, &conflict)
+}
+
+// ownerKey is the value of the ownership annotation for the DynamicResource
+func ownerKey(dynamicResource *dynamickubev1alpha1.DynamicResource) string {
+	return client.ObjectKeyFromObject(dynamicResource).String()
+}
+
+// foreignOwner describes the controller of obj other than the DynamicResource, or returns "" if there is none.
+// Besides controller owner references, the ownership labels and annotation of other DynamicResources are considered.
+func foreignOwner(obj client.Object, dynamicResource *dynamickubev1alpha1.DynamicResource) string {
+	if owner := metav1.GetControllerOf(obj); owner != nil && owner.UID != dynamicResource.UID {
+		return fmt.Sprintf("%s %s", owner.Kind, owner.Name)
+	}
+
+	if uid, ok := obj.GetLabels()[dynamickubev1alpha1.OwnerUIDLabel]; ok && uid != string(dynamicResource.UID) {
+		if key, ok := labelOwner(obj); ok {
+			return fmt.Sprintf("DynamicResource %s", key)
+		}
+		return fmt.Sprintf("DynamicResource with UID %s", uid)
+	}
+
+	if key, ok := obj.GetAnnotations()[dynamickubev1alpha1.OwnerAnnotation]; ok && key != ownerKey(dynamicResource) {
+		return fmt.Sprintf("DynamicResource %s", key)
+	}
+
+	return ""
+}
+
+// adoptTarget decides whether the existing target may be written according to the adoption policy of the
+// DynamicResource. When adopting a target controlled by someone else, control is transferred by removing
+// the previous controller owner reference and ownership labels before the rendered target is applied.
+func (r *DynamicResourceReconciler) adoptTarget(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, live *unstructured.Unstructured) error {
+	if live == nil || controlledBy(live, dynamicResource) {
+		return nil
+	}
+
+	owner := foreignOwner(live, dynamicResource)
+
+	switch dynamicResource.Spec.AdoptionPolicy {
+	case dynamickubev1alpha1.AdoptionPolicyNever:
+		return &adoptionConflict{message: fmt.Sprintf("%s %s already exists and adoption policy is Never", live.GetKind(), client.ObjectKeyFromObject(live))}
+
+	case dynamickubev1alpha1.AdoptionPolicyIfUnowned, "":
+		if owner != "" {
+			return &adoptionConflict{message: fmt.Sprintf("%s %s is controlled by %s", live.GetKind(), client.ObjectKeyFromObject(live), owner)}
+		}
+
+	case dynamickubev1alpha1.AdoptionPolicyAlways:
+		if owner != "" {
+			patch := client.MergeFrom(live.DeepCopy())
+			releaseControl(live)
+
+			if err := c.Patch(ctx, live, patch); err != nil {
+				return errors.WithMessagef(err, "Failed to take over control from %s", owner)
+			}
+		}
+
+	default:
+		return errors.New(fmt.Sprintf("Unknown adoption policy '%s'", dynamicResource.Spec.AdoptionPolicy))
+	}
+
+	log.FromContext(ctx).Info("Adopting existing target", "resource", client.ObjectKeyFromObject(live), "previousOwner", owner)
+	r.recorder(ctx).Eventf(dynamicResource, corev1.EventTypeNormal, "TargetAdopted", "Adopted existing %s %s", live.GetKind(), client.ObjectKeyFromObject(live))
+
+	return nil
+}
+
+// releaseControl removes the controller owner reference and all ownership labels and annotations from obj
+func releaseControl(obj client.Object) {
+	refs := obj.GetOwnerReferences()
+	kept := make([]metav1.OwnerReference, 0, len(refs))
+
+	for _, ref := range refs {
+		if ref.Controller == nil || !*ref.Controller {
+			kept = append(kept, ref)
+		}
+	}
+
+	obj.SetOwnerReferences(kept)
+
+	labels := obj.GetLabels()
+	delete(labels, dynamickubev1alpha1.OwnerNamespaceLabel)
+	delete(labels, dynamickubev1alpha1.OwnerUIDLabel)
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	delete(annotations, dynamickubev1alpha1.OwnerAnnotation)
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestForeignOwner(t *testing.T) {
+	dynamicResource := newTestDynamicResource("sample", nil)
+	other := newTestDynamicResource("other", nil)
+	gvk := dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource")
+
+	tests := []struct {
+		name        string
+		owners      []metav1.OwnerReference
+		labels      map[string]string
+		annotations map[string]string
+		expected    string
+	}{
+		{name: "unowned", expected: ""},
+		{name: "controlled by the DynamicResource", owners: []metav1.OwnerReference{*metav1.NewControllerRef(dynamicResource, gvk)}, expected: ""},
+		{name: "controlled by another DynamicResource", owners: []metav1.OwnerReference{*metav1.NewControllerRef(other, gvk)}, expected: "DynamicResource other"},
+		{
+			name:     "controlled by a Deployment",
+			owners:   []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "app-uid", Controller: boolPtr(true)}},
+			expected: "Deployment app",
+		},
+		{
+			name:     "owned without controller",
+			owners:   []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "app-uid"}},
+			expected: "",
+		},
+		{
+			name:     "labels of the DynamicResource",
+			labels:   map[string]string{dynamickubev1alpha1.OwnerNamespaceLabel: "default", dynamickubev1alpha1.OwnerUIDLabel: "sample-uid"},
+			expected: "",
+		},
+		{
+			name:        "labels of another DynamicResource",
+			labels:      map[string]string{dynamickubev1alpha1.OwnerNamespaceLabel: "team", dynamickubev1alpha1.OwnerUIDLabel: "other-uid"},
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "team/other"},
+			expected:    "DynamicResource team/other",
+		},
+		{
+			name:     "UID label of another DynamicResource",
+			labels:   map[string]string{dynamickubev1alpha1.OwnerUIDLabel: "other-uid"},
+			expected: "DynamicResource with UID other-uid",
+		},
+		{
+			name:        "annotation of the DynamicResource",
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "default/sample"},
+			expected:    "",
+		},
+		{
+			name:        "annotation of another DynamicResource",
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "default/other"},
+			expected:    "DynamicResource default/other",
+		},
+	}
+
+	for _, test := range tests {
+		obj := newTestObject("v1", "Secret", "default", "target")
+		obj.SetOwnerReferences(test.owners)
+		obj.SetLabels(test.labels)
+		obj.SetAnnotations(test.annotations)
+
+		if owner := foreignOwner(obj, dynamicResource); owner != test.expected {
+			t.Errorf("%s: expected owner '%s', got '%s'", test.name, test.expected, owner)
+		}
+	}
+}
+
+func TestAdoptTarget(t *testing.T) {
+	gvk := dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource")
+	dynamicResource := newTestDynamicResource("sample", nil)
+
+	unowned := func() *unstructured.Unstructured {
+		return newTestObject("v1", "Secret", "default", "target")
+	}
+	controlled := func() *unstructured.Unstructured {
+		u := unowned()
+		u.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(dynamicResource, gvk)})
+		return u
+	}
+	foreign := func() *unstructured.Unstructured {
+		u := unowned()
+		u.SetOwnerReferences([]metav1.OwnerReference{
+			*metav1.NewControllerRef(newTestDynamicResource("other", nil), gvk),
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "config", UID: "config-uid"},
+		})
+		u.SetLabels(map[string]string{"app": "web", dynamickubev1alpha1.OwnerUIDLabel: "other-uid"})
+		u.SetAnnotations(map[string]string{dynamickubev1alpha1.OwnerAnnotation: "default/other"})
+		return u
+	}
+
+	tests := []struct {
+		name     string
+		policy   dynamickubev1alpha1.AdoptionPolicy
+		live     *unstructured.Unstructured
+		adopted  bool
+		conflict bool
+		wantErr  bool
+	}{
+		{name: "missing target", policy: dynamickubev1alpha1.AdoptionPolicyNever, live: nil},
+		{name: "controlled target", policy: dynamickubev1alpha1.AdoptionPolicyNever, live: controlled()},
+		{name: "Never refuses unowned target", policy: dynamickubev1alpha1.AdoptionPolicyNever, live: unowned(), conflict: true},
+		{name: "Never refuses foreign target", policy: dynamickubev1alpha1.AdoptionPolicyNever, live: foreign(), conflict: true},
+		{name: "IfUnowned adopts unowned target", policy: dynamickubev1alpha1.AdoptionPolicyIfUnowned, live: unowned(), adopted: true},
+		{name: "IfUnowned refuses foreign target", policy: dynamickubev1alpha1.AdoptionPolicyIfUnowned, live: foreign(), conflict: true},
+		{name: "default adopts unowned target", live: unowned(), adopted: true},
+		{name: "default refuses foreign target", live: foreign(), conflict: true},
+		{name: "Always adopts unowned target", policy: dynamickubev1alpha1.AdoptionPolicyAlways, live: unowned(), adopted: true},
+		{name: "Always takes over foreign target", policy: dynamickubev1alpha1.AdoptionPolicyAlways, live: foreign(), adopted: true},
+		{name: "unknown policy", policy: "Sometimes", live: unowned(), wantErr: true},
+	}
+
+	for _, test := range tests {
+		var objs []client.Object
+		if test.live != nil {
+			objs = append(objs, test.live.DeepCopy())
+		}
+		r := newTestReconciler(objs...)
+		dynamicResource.Spec.AdoptionPolicy = test.policy
+
+		err := r.adoptTarget(context.Background(), r.Client, dynamicResource, test.live)
+		switch {
+		case test.conflict:
+			if !isAdoptionConflict(err) {
+				t.Errorf("%s: expected adoption conflict, got %v", test.name, err)
+			}
+		case test.wantErr:
+			if err == nil || isAdoptionConflict(err) {
+				t.Errorf("%s: expected error, got %v", test.name, err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		events := r.Recorder.(*record.FakeRecorder).Events
+		if test.adopted != (len(events) == 1) {
+			t.Errorf("%s: expected adoption %v, got %d events", test.name, test.adopted, len(events))
+		}
+		if !test.adopted || test.live == nil {
+			continue
+		}
+
+		// Control is released on the server, so that the rendered target can be applied
+		stored := newTestObject("v1", "Secret", "default", "target")
+		if err := r.Get(context.Background(), client.ObjectKeyFromObject(stored), stored); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if owner := foreignOwner(stored, dynamicResource); owner != "" {
+			t.Errorf("%s: expected control to be released, still controlled by %s", test.name, owner)
+		}
+	}
+
+	// Owner references without controller and unrelated labels are kept
+	target := foreign()
+	releaseControl(target)
+	if refs := target.GetOwnerReferences(); len(refs) != 1 || refs[0].Kind != "ConfigMap" {
+		t.Errorf("expected only the controller owner reference to be removed, got %v", refs)
+	}
+	if labels := target.GetLabels(); len(labels) != 1 || labels["app"] != "web" {
+		t.Errorf("expected only the ownership labels to be removed, got %v", labels)
+	}
+	if annotations := target.GetAnnotations(); len(annotations) != 0 {
+		t.Errorf("expected the ownership annotation to be removed, got %v", annotations)
+	}
+}
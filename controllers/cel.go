@@ -0,0 +1,179 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	celtypes "github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
+)
+
+// celPrograms caches the compiled CEL programs of the transformations of each DynamicResource.
+// Expressions can only change with the spec, so programs are compiled once per generation.
+type celPrograms struct {
+	lock     sync.Mutex
+	programs map[types.NamespacedName]*celGeneration
+}
+
+// celGeneration holds the programs compiled for one generation of a DynamicResource, by transformation index
+type celGeneration struct {
+	uid        types.UID
+	generation int64
+	programs   map[int]cel.Program
+}
+
+func newCELPrograms() *celPrograms {
+	return &celPrograms{programs: map[types.NamespacedName]*celGeneration{}}
+}
+
+// program returns the compiled expression of the transformation with the given index.
+// Programs of previous generations of the DynamicResource are discarded.
+func (p *celPrograms) program(dynamicResource *dynamickubev1alpha1.DynamicResource, index int, trans dynamickubev1alpha1.DynamicResourceTransformation) (cel.Program, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := types.NamespacedName{Namespace: dynamicResource.Namespace, Name: dynamicResource.Name}
+
+	cached, ok := p.programs[key]
+	if !ok || cached.uid != dynamicResource.UID || cached.generation != dynamicResource.Generation {
+		cached = &celGeneration{
+			uid:        dynamicResource.UID,
+			generation: dynamicResource.Generation,
+			programs:   map[int]cel.Program{},
+		}
+		p.programs[key] = cached
+	}
+
+	if program, ok := cached.programs[index]; ok {
+		return program, nil
+	}
+
+	program, err := transform.CompileCEL(trans.CEL, trans.Sources)
+	if err != nil {
+		return nil, err
+	}
+	cached.programs[index] = program
+
+	return program, nil
+}
+
+// forget discards the programs of a DynamicResource that was deleted
+func (p *celPrograms) forget(key types.NamespacedName) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.programs, key)
+}
+
+// evaluateCEL runs a compiled expression with the resolved source values and returns the result
+// as a value that can be injected into an unstructured object
+func evaluateCEL(program cel.Program, values map[string]interface{}) (interface{}, error) {
+	result, _, err := program.Eval(values)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to evaluate CEL expression")
+	}
+
+	return celValue(result)
+}
+
+// celValue converts a CEL value to the types used by unstructured objects
+func celValue(val ref.Val) (interface{}, error) {
+	switch v := val.(type) {
+	case celtypes.Null:
+		return nil, nil
+	case celtypes.Bool:
+		return bool(v), nil
+	case celtypes.Int:
+		return int64(v), nil
+	case celtypes.Uint:
+		return int64(v), nil
+	case celtypes.Double:
+		return float64(v), nil
+	case celtypes.String:
+		return string(v), nil
+	case celtypes.Bytes:
+		return string(v), nil
+
+	case traits.Mapper:
+		result := map[string]interface{}{}
+		for it := v.Iterator(); it.HasNext() == celtypes.True; {
+			key := it.Next()
+			name, ok := key.(celtypes.String)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("CEL expression returned a map with key of type '%s', but keys need to be strings", key.Type().TypeName()))
+			}
+
+			item, err := celValue(v.Get(key))
+			if err != nil {
+				return nil, err
+			}
+			result[string(name)] = item
+		}
+		return result, nil
+
+	case traits.Lister:
+		result := []interface{}{}
+		for it := v.Iterator(); it.HasNext() == celtypes.True; {
+			item, err := celValue(it.Next())
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+		}
+		return result, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("CEL expression returned unsupported type '%s'", val.Type().TypeName()))
+}
+
+// convertCELResult converts the result of an expression to the value type of the transformation.
+// String renders scalars as they are printed by CEL and maps or lists as JSON document.
+// Base64 encoded results are decoded before the conversion if decode is set.
+func convertCELResult(result interface{}, valueType dynamickubev1alpha1.ValueType, decode bool) (interface{}, error) {
+	if decode {
+		decoded, err := base64Decode(result)
+		if err != nil {
+			return nil, err
+		}
+		result = decoded
+	}
+
+	if valueType != dynamickubev1alpha1.ValueTypeString && valueType != "" {
+		return convertValue(result, valueType)
+	}
+
+	switch v := result.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Failed to render CEL result")
+		}
+		return string(data), nil
+	default:
+		return toString(v), nil
+	}
+}
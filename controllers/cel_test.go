@@ -0,0 +1,167 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
+)
+
+// untypedSources returns named source references without valueType
+func untypedSources(names ...string) map[string]dynamickubev1alpha1.ExternalFieldRef {
+	sources := map[string]dynamickubev1alpha1.ExternalFieldRef{}
+	for _, name := range names {
+		sources[name] = *newTestFieldRef("v1", "ConfigMap", name, "{.data."+name+"}")
+	}
+
+	return sources
+}
+
+func TestEvaluateCEL(t *testing.T) {
+	values := map[string]interface{}{
+		"replicas": int64(2),
+		"tier":     "production",
+		"user":     "YWRtaW4=",
+		"ports":    []interface{}{int64(80), int64(443)},
+		"labels":   map[string]interface{}{"app": "web"},
+	}
+	sources := untypedSources("labels", "ports", "replicas", "tier", "user")
+
+	tests := []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"replicas * 2", int64(4)},
+		{"tier == 'production' ? 3 : 1", int64(3)},
+		{"'user-' + string(base64.decode(user))", "user-admin"},
+		{"tier.upperAscii()", "PRODUCTION"},
+		{"ports.filter(p, p > 100)", []interface{}{int64(443)}},
+		{"ports.map(p, {'port': p})", []interface{}{map[string]interface{}{"port": int64(80)}, map[string]interface{}{"port": int64(443)}}},
+		{"labels.app", "web"},
+		{"1.5", 1.5},
+		{"null", nil},
+	}
+
+	for _, test := range tests {
+		program, err := transform.CompileCEL(test.expression, sources)
+		if err != nil {
+			t.Errorf("%s: unexpected compile error: %v", test.expression, err)
+			continue
+		}
+
+		value, err := evaluateCEL(program, values)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.expression, test.expected, value)
+		}
+	}
+}
+
+func TestEvaluateCELErrors(t *testing.T) {
+	if _, err := transform.CompileCEL("replicas *", untypedSources("replicas")); err == nil {
+		t.Errorf("expected syntax error")
+	}
+	if _, err := transform.CompileCEL("unknown + 1", untypedSources("replicas")); err == nil {
+		t.Errorf("expected undeclared reference error")
+	}
+
+	for _, expression := range []string{"replicas / 0", "labels.missing", "{1: 'a'}", "duration('1s')"} {
+		program, err := transform.CompileCEL(expression, untypedSources("replicas", "labels"))
+		if err != nil {
+			t.Errorf("%s: unexpected compile error: %v", expression, err)
+			continue
+		}
+		if value, err := evaluateCEL(program, map[string]interface{}{"replicas": int64(1), "labels": map[string]interface{}{}}); err == nil {
+			t.Errorf("%s: expected error, got %#v", expression, value)
+		}
+	}
+}
+
+func TestConvertCELResult(t *testing.T) {
+	tests := []struct {
+		result    interface{}
+		valueType dynamickubev1alpha1.ValueType
+		decode    bool
+		expected  interface{}
+	}{
+		{int64(3), "", false, "3"},
+		{true, dynamickubev1alpha1.ValueTypeString, false, "true"},
+		{[]interface{}{"a", int64(1)}, dynamickubev1alpha1.ValueTypeString, false, `["a",1]`},
+		{int64(3), dynamickubev1alpha1.ValueTypeInt, false, int64(3)},
+		{"3", dynamickubev1alpha1.ValueTypeInt, false, int64(3)},
+		{map[string]interface{}{"a": "b"}, dynamickubev1alpha1.ValueTypeObject, false, map[string]interface{}{"a": "b"}},
+		{"YWRtaW4=", "", true, "admin"},
+	}
+
+	for _, test := range tests {
+		value, err := convertCELResult(test.result, test.valueType, test.decode)
+		if err != nil {
+			t.Errorf("%#v as %s: unexpected error: %v", test.result, test.valueType, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%#v as %s: expected %#v, got %#v", test.result, test.valueType, test.expected, value)
+		}
+	}
+
+	if _, err := convertCELResult("three", dynamickubev1alpha1.ValueTypeInt, false); err == nil {
+		t.Errorf("expected type mismatch")
+	}
+}
+
+func TestCELProgramsCache(t *testing.T) {
+	programs := newCELPrograms()
+	dynamicResource := &dynamickubev1alpha1.DynamicResource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sample", UID: "uid-1", Generation: 1},
+	}
+	trans := dynamickubev1alpha1.DynamicResourceTransformation{CEL: "1 + 1"}
+
+	first, err := programs.program(dynamicResource, 0, trans)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cached, _ := programs.program(dynamicResource, 0, trans)
+	if first != cached {
+		t.Errorf("expected the program to be compiled once per generation")
+	}
+
+	// A new generation may change the expression
+	dynamicResource.Generation = 2
+	trans.CEL = "2 + 2"
+	recompiled, _ := programs.program(dynamicResource, 0, trans)
+	if value, _ := evaluateCEL(recompiled, nil); value != int64(4) {
+		t.Errorf("expected the program to be recompiled for a new generation, got %#v", value)
+	}
+
+	programs.forget(types.NamespacedName{Namespace: "default", Name: "sample"})
+	if len(programs.programs) != 0 {
+		t.Errorf("expected programs of deleted DynamicResources to be discarded")
+	}
+
+	if _, err := programs.program(dynamicResource, 0, dynamickubev1alpha1.DynamicResourceTransformation{CEL: "1 +"}); err == nil {
+		t.Errorf("expected compile error")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// renderedHashAnnotation holds the hash of the rendered target that was last written by the controller
+const renderedHashAnnotation = "dynamic.kube/rendered-hash"
+
+// setRenderedHash annotates the rendered target with a hash of its content
+func setRenderedHash(rendered *unstructured.Unstructured) error {
+	data, err := json.Marshal(rendered.Object)
+	if err != nil {
+		return err
+	}
+
+	annotations := rendered.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[renderedHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256(data))
+	rendered.SetAnnotations(annotations)
+
+	return nil
+}
+
+// writeOnlyFields are accepted by the API server, but never stored as they are
+var writeOnlyFields = map[schema.GroupKind][][]string{
+	{Kind: "Secret"}: {{"stringData"}},
+}
+
+// normalizeTarget returns the rendered target as the API server would store it, by writing it in dry-run mode
+// with the apply strategy of the DynamicResource. This includes the fields defaulted by the API server and
+// drops write-only fields. If the dry-run fails, the rendered target without write-only fields is returned.
+func (r *DynamicResourceReconciler) normalizeTarget(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, rendered, live *unstructured.Unstructured) *unstructured.Unstructured {
+	var normalized *unstructured.Unstructured
+	var err error
+
+	switch dynamicResource.Spec.ApplyStrategy {
+	case dynamickubev1alpha1.ApplyStrategyServerSideApply:
+		normalized = rendered.DeepCopy()
+
+		opts := []client.PatchOption{client.FieldOwner(FieldManager), client.DryRunAll}
+		if dynamicResource.Spec.ForceConflicts {
+			opts = append(opts, client.ForceOwnership)
+		}
+		err = c.Patch(ctx, normalized, client.Apply, opts...)
+
+	default:
+		normalized, err = withServerManagedFields(rendered, live)
+		if err == nil {
+			err = c.Update(ctx, normalized, client.DryRunAll)
+		}
+	}
+
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to normalize target, comparing the rendered fields instead",
+			"resource", client.ObjectKeyFromObject(rendered), "error", err.Error())
+		return withoutWriteOnlyFields(rendered)
+	}
+
+	return normalized
+}
+
+// withoutWriteOnlyFields returns a copy of the rendered target without the write-only fields of its kind
+func withoutWriteOnlyFields(rendered *unstructured.Unstructured) *unstructured.Unstructured {
+	desired := rendered.DeepCopy()
+
+	for _, path := range writeOnlyFields[rendered.GroupVersionKind().GroupKind()] {
+		unstructured.RemoveNestedField(desired.Object, path...)
+	}
+
+	return desired
+}
+
+// targetUpToDate checks whether the live target has all fields of the desired one, in which case it is not updated.
+// Targets are always written with server-side apply, as it may also change the ownership of fields.
+func targetUpToDate(dynamicResource *dynamickubev1alpha1.DynamicResource, live, desired *unstructured.Unstructured) bool {
+	if live == nil || dynamicResource.Spec.ApplyStrategy == dynamickubev1alpha1.ApplyStrategyServerSideApply {
+		return false
+	}
+
+	return len(diffFields(desired.Object, live.Object, "")) == 0
+}
+
+// detectDrift compares the live target with the desired one and returns the fields that differ.
+// desired is the rendered target as normalized by the API server, see normalizeTarget.
+// Only targets that were last written from the same rendered content are considered, as any
+// other difference stems from a changed DynamicResource or changed source objects.
+func detectDrift(live, desired *unstructured.Unstructured) []string {
+	if live == nil || live.GetAnnotations()[renderedHashAnnotation] != desired.GetAnnotations()[renderedHashAnnotation] {
+		return nil
+	}
+
+	return diffFields(desired.Object, live.Object, "")
+}
+
+// diffFields returns the dot-delimited paths of all fields of desired that are missing or differ in live.
+// Fields that only exist in live are ignored, as they are usually set by other controllers, and so are
+// the fields managed by the API server.
+func diffFields(desired, live map[string]interface{}, prefix string) []string {
+	var fields []string
+
+	for key, desiredValue := range desired {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if serverManaged(path) {
+			continue
+		}
+
+		liveValue, found := live[key]
+		if !found {
+			fields = append(fields, path)
+			continue
+		}
+
+		desiredMap, desiredIsMap := desiredValue.(map[string]interface{})
+		liveMap, liveIsMap := liveValue.(map[string]interface{})
+		if desiredIsMap && liveIsMap {
+			fields = append(fields, diffFields(desiredMap, liveMap, path)...)
+		} else if !equality.Semantic.DeepEqual(desiredValue, liveValue) {
+			fields = append(fields, path)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+// serverManaged checks whether the dot-delimited path is one of the serverManagedFields
+func serverManaged(path string) bool {
+	for _, fieldPath := range serverManagedFields {
+		if path == strings.Join(fieldPath, ".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  map[string]interface{}
+		live     map[string]interface{}
+		expected []string
+	}{
+		{
+			name:    "equal",
+			desired: map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+			live:    map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+		},
+		{
+			name:     "changed and missing fields",
+			desired:  map[string]interface{}{"data": map[string]interface{}{"a": "1", "b": "2", "c": "3"}},
+			live:     map[string]interface{}{"data": map[string]interface{}{"a": "1", "b": "changed"}},
+			expected: []string{"data.b", "data.c"},
+		},
+		{
+			name:    "fields only in live are ignored",
+			desired: map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+			live:    map[string]interface{}{"data": map[string]interface{}{"a": "1", "extra": "x"}, "type": "Opaque"},
+		},
+		{
+			name: "server managed fields are ignored",
+			desired: map[string]interface{}{"metadata": map[string]interface{}{
+				"name":            "target",
+				"resourceVersion": "43",
+				"managedFields":   []interface{}{map[string]interface{}{"time": "2022-01-02T00:00:00Z"}},
+			}},
+			live: map[string]interface{}{"metadata": map[string]interface{}{
+				"name":            "target",
+				"resourceVersion": "42",
+				"managedFields":   []interface{}{map[string]interface{}{"time": "2022-01-01T00:00:00Z"}},
+			}},
+		},
+		{
+			name: "lists are compared with their server defaults",
+			desired: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "app", "image": "nginx", "imagePullPolicy": "Always"}},
+			}},
+			live: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "app", "image": "nginx", "imagePullPolicy": "Always"}},
+			}},
+		},
+		{
+			name: "changed list items",
+			desired: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "app", "image": "nginx"}},
+			}},
+			live: map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "app", "image": "httpd"}},
+			}},
+			expected: []string{"spec.containers"},
+		},
+		{
+			name:     "map replaced by scalar",
+			desired:  map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+			live:     map[string]interface{}{"data": "x"},
+			expected: []string{"data"},
+		},
+	}
+
+	for _, test := range tests {
+		fields := diffFields(test.desired, test.live, "")
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, fields)
+		}
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	rendered := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "target", "namespace": "default"},
+		"data":       map[string]interface{}{"a": "1"},
+	}}
+	if err := setRenderedHash(rendered); err != nil {
+		t.Fatal(err)
+	}
+
+	live := rendered.DeepCopy()
+	_ = unstructured.SetNestedField(live.Object, "changed", "data", "a")
+
+	if fields := detectDrift(nil, rendered); fields != nil {
+		t.Errorf("expected no drift for missing targets, got %v", fields)
+	}
+	if fields := detectDrift(live, rendered); !reflect.DeepEqual(fields, []string{"data.a"}) {
+		t.Errorf("expected drift of data.a, got %v", fields)
+	}
+
+	// Targets written from other rendered content are updated, but not reported as drift
+	live.SetAnnotations(map[string]string{renderedHashAnnotation: "outdated"})
+	if fields := detectDrift(live, rendered); fields != nil {
+		t.Errorf("expected no drift for outdated targets, got %v", fields)
+	}
+}
+
+// normalizingClient imitates the API server normalizing objects written in dry-run mode
+type normalizingClient struct {
+	client.Client
+	err error
+}
+
+func (c *normalizingClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	u := obj.(*unstructured.Unstructured)
+	stringData, _, _ := unstructured.NestedStringMap(u.Object, "stringData")
+	for key, value := range stringData {
+		_ = unstructured.SetNestedField(u.Object, base64.StdEncoding.EncodeToString([]byte(value)), "data", key)
+	}
+	unstructured.RemoveNestedField(u.Object, "stringData")
+	_ = unstructured.SetNestedField(u.Object, "Opaque", "type")
+
+	return nil
+}
+
+func TestNormalizeTarget(t *testing.T) {
+	rendered := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "target", "namespace": "default"},
+		"stringData": map[string]interface{}{"url": "postgres://db"},
+	}}
+	if err := setRenderedHash(rendered); err != nil {
+		t.Fatal(err)
+	}
+
+	live := rendered.DeepCopy()
+	live.SetResourceVersion("42")
+	unstructured.RemoveNestedField(live.Object, "stringData")
+	_ = unstructured.SetNestedField(live.Object, base64.StdEncoding.EncodeToString([]byte("postgres://db")), "data", "url")
+	_ = unstructured.SetNestedField(live.Object, "Opaque", "type")
+
+	r := &DynamicResourceReconciler{}
+	dynamicResource := &dynamickubev1alpha1.DynamicResource{}
+	c := &normalizingClient{Client: fake.NewClientBuilder().Build()}
+
+	desired := r.normalizeTarget(context.Background(), c, dynamicResource, rendered, live)
+	if desired.GetResourceVersion() != "42" {
+		t.Errorf("expected the live resourceVersion to be carried over, got %q", desired.GetResourceVersion())
+	}
+	if fields := detectDrift(live, desired); fields != nil {
+		t.Errorf("expected no drift of stringData, got %v", fields)
+	}
+
+	// Manual changes of the stored data are still detected
+	drifted := live.DeepCopy()
+	_ = unstructured.SetNestedField(drifted.Object, "Y2hhbmdlZA==", "data", "url")
+	if fields := detectDrift(drifted, desired); !reflect.DeepEqual(fields, []string{"data.url"}) {
+		t.Errorf("expected drift of data.url, got %v", fields)
+	}
+
+	// Without dry-run, write-only fields are dropped from the comparison
+	c.err = errors.New("dry-run not supported")
+	desired = r.normalizeTarget(context.Background(), c, dynamicResource, rendered, live)
+	if _, found := desired.Object["stringData"]; found {
+		t.Errorf("expected stringData to be removed")
+	}
+	if fields := detectDrift(live, desired); fields != nil {
+		t.Errorf("expected no drift of stringData, got %v", fields)
+	}
+}
+
+func TestTargetUpToDate(t *testing.T) {
+	rendered := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "target", "namespace": "default"},
+		"data":       map[string]interface{}{"password": "c2VjcmV0"},
+	}}
+	if err := setRenderedHash(rendered); err != nil {
+		t.Fatal(err)
+	}
+
+	// The API server defaults the type of Secrets
+	live := rendered.DeepCopy()
+	live.SetResourceVersion("42")
+	_ = unstructured.SetNestedField(live.Object, "Opaque", "type")
+
+	r := &DynamicResourceReconciler{}
+	dynamicResource := &dynamickubev1alpha1.DynamicResource{}
+	c := &normalizingClient{Client: fake.NewClientBuilder().Build()}
+	desired := r.normalizeTarget(context.Background(), c, dynamicResource, rendered, live)
+
+	if !targetUpToDate(dynamicResource, live, desired) {
+		t.Errorf("expected target with server defaults to be up to date")
+	}
+	if targetUpToDate(dynamicResource, nil, desired) {
+		t.Errorf("expected missing target not to be up to date")
+	}
+
+	changed := live.DeepCopy()
+	_ = unstructured.SetNestedField(changed.Object, "Y2hhbmdlZA==", "data", "password")
+	if targetUpToDate(dynamicResource, changed, desired) {
+		t.Errorf("expected target with changed data not to be up to date")
+	}
+
+	outdated := live.DeepCopy()
+	outdated.SetAnnotations(map[string]string{renderedHashAnnotation: "outdated"})
+	if targetUpToDate(dynamicResource, outdated, desired) {
+		t.Errorf("expected target written from other rendered content not to be up to date")
+	}
+
+	dynamicResource.Spec.ApplyStrategy = dynamickubev1alpha1.ApplyStrategyServerSideApply
+	if targetUpToDate(dynamicResource, live, desired) {
+		t.Errorf("expected server-side applied targets to always be written")
+	}
+}
@@ -21,32 +21,68 @@ import (
 	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/jsonpath"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"strings"
-	"time"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-
-	"k8s.io/kubectl/pkg/cmd/get"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/sensitive"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
 )
 
+// FieldManager is the field manager name used when writing target objects
+const FieldManager = "dynamic-resources-controller"
+
 // DynamicResourceReconciler reconciles a DynamicResource object
 type DynamicResourceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	controller    controller.Controller
+	watchesLock   sync.Mutex
+	targetWatches map[schema.GroupVersionKind]struct{}
+	sources       *sourceInformers
+	programs      *celPrograms
+
+	Recorder record.EventRecorder
+
+	// AllowCrossNamespaceSources permits reading fieldFrom sources from other namespaces
+	AllowCrossNamespaceSources bool
+
+	// RequireServiceAccount rejects DynamicResources that do not specify a ServiceAccount to impersonate
+	RequireServiceAccount bool
+
+	config *rest.Config
 }
 
 //+kubebuilder:rbac:groups=dynamic.kube,resources=dynamicresources,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=dynamic.kube,resources=dynamicresources/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=dynamic.kube,resources=dynamicresources/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+//+kubebuilder:rbac:groups=dynamic.kube,resources=dynamicresourcepolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -54,6 +90,11 @@ type DynamicResourceReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
 func (r *DynamicResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// Values read from sensitive sources are redacted from logs, events and the status
+	tracker := sensitive.NewTracker()
+	ctx = sensitive.NewContext(ctx, tracker)
+	ctx = log.IntoContext(ctx, sensitive.Logger(log.FromContext(ctx), tracker))
+
 	logger := log.FromContext(ctx)
 
 	logger.Info("Reconciling...")
@@ -66,105 +107,647 @@ func (r *DynamicResourceReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		// we'll ignore not-found errors, since they can't be fixed by an immediate
 		// requeue (we'll need to wait for a new notification), and we can get them
 		// on deleted requests.
+		if apierrors.IsNotFound(err) {
+			r.sources.retain(ctx, req.NamespacedName, nil)
+			r.programs.forget(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	u := &unstructured.Unstructured{}
+	// Handle targets according to the deletion policy before the DynamicResource goes away
+	if !dynamicResource.DeletionTimestamp.IsZero() {
+		r.sources.retain(ctx, req.NamespacedName, nil)
+		r.programs.forget(req.NamespacedName)
+		return ctrl.Result{}, r.finalize(ctx, &dynamicResource)
+	}
 
-	// https://stackoverflow.com/questions/61200605/generic-client-get-for-custom-kubernetes-go-operator
+	if !controllerutil.ContainsFinalizer(&dynamicResource, Finalizer) {
+		controllerutil.AddFinalizer(&dynamicResource, Finalizer)
+		if err := r.Update(ctx, &dynamicResource); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	original := dynamicResource.DeepCopy()
 
-	// Prepare Target object
-	u.SetUnstructuredContent(dynamicResource.Spec.Target.Object)
+	result, err := r.reconcileTargets(ctx, &dynamicResource)
+	err = tracker.RedactError(err)
 
-	// Define owner reference
-	gvk, err := apiutil.GVKForObject(&dynamicResource, r.Scheme)
+	// Report the outcome in the status
+	dynamicResource.Status.ObservedGeneration = dynamicResource.Generation
+	if err != nil {
+		dynamicResource.Status.LastError = err.Error()
+		setCondition(&dynamicResource, dynamickubev1alpha1.ConditionReady, metav1.ConditionFalse, dynamickubev1alpha1.ReasonReconcileFailed, err.Error())
+	} else {
+		dynamicResource.Status.LastError = ""
+		setCondition(&dynamicResource, dynamickubev1alpha1.ConditionReady, metav1.ConditionTrue, dynamickubev1alpha1.ReasonReconciled, "Target is up to date")
+	}
+	redactStatus(tracker, &dynamicResource.Status)
+
+	if statusErr := r.Status().Patch(ctx, &dynamicResource, client.MergeFrom(original)); statusErr != nil {
+		if err != nil {
+			logger.Error(statusErr, "Failed to update status")
+			return result, err
+		}
+		return result, statusErr
+	}
+
+	return result, err
+}
+
+// reconcileTargets renders the targets of the DynamicResource and writes them to the cluster.
+// The conditions of the individual steps are recorded in the status of the DynamicResource.
+func (r *DynamicResourceReconciler) reconcileTargets(ctx context.Context, dynamicResource *dynamickubev1alpha1.DynamicResource) (ctrl.Result, error) {
+	// Release the informers of source kinds that are no longer read
+	r.sources.retain(ctx, client.ObjectKeyFromObject(dynamicResource), sourceKinds(dynamicResource))
+
+	// Sources and targets must be allowed by the applicable policies before anything is read or written
+	violations, err := r.policyViolations(ctx, dynamicResource)
+	if err != nil {
+		return ctrl.Result{}, errors.WithMessage(err, "Failed to evaluate DynamicResourcePolicies")
+	}
+
+	if len(violations) > 0 {
+		message := strings.Join(violations, "; ")
+		setCondition(dynamicResource, dynamickubev1alpha1.ConditionPolicyViolation, metav1.ConditionTrue, dynamickubev1alpha1.ReasonPolicyViolated, message)
+		return ctrl.Result{}, errors.New(message)
+	}
+
+	setCondition(dynamicResource, dynamickubev1alpha1.ConditionPolicyViolation, metav1.ConditionFalse, dynamickubev1alpha1.ReasonPolicyCompliant, "Allowed by all applicable policies")
+
+	// Sources are read and targets are written with the permissions of the ServiceAccount, if any
+	c, err := r.clientFor(dynamicResource)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	ref := *metav1.NewControllerRef(&dynamicResource, gvk)
-	refs := u.GetOwnerReferences()
-	refs = append(refs, ref)
+	targets, err := r.renderTargets(ctx, c, dynamicResource)
+	if err != nil {
+		setCondition(dynamicResource, dynamickubev1alpha1.ConditionSourcesResolved, metav1.ConditionFalse, dynamickubev1alpha1.ReasonResolveFailed, err.Error())
+		return ctrl.Result{}, err
+	}
 
-	u.SetOwnerReferences(append(u.GetOwnerReferences(), ref))
+	setCondition(dynamicResource, dynamickubev1alpha1.ConditionSourcesResolved, metav1.ConditionTrue, dynamickubev1alpha1.ReasonResolved, "All sources resolved")
 
-	// Resolve Transformations
-	for _, trans := range dynamicResource.Spec.Transformations {
+	// All targets are applied, even if one of them fails
+	previous := dynamicResource.Status.Targets
+	refs := make([]dynamickubev1alpha1.TargetReference, 0, len(targets))
+	var errs, conflicts []error
 
-		// Handle fieldFrom transfomation
-		src := &unstructured.Unstructured{}
+	for _, u := range targets {
+		if err := r.reconcileTarget(ctx, c, dynamicResource, u); err != nil {
+			if isAdoptionConflict(err) {
+				conflicts = append(conflicts, err)
+			}
+			errs = append(errs, errors.WithMessagef(err, "%s %s", u.GetKind(), client.ObjectKeyFromObject(u)))
 
-		src.SetAPIVersion(trans.FieldFrom.APIVersion)
-		src.SetKind(trans.FieldFrom.Kind)
+			// Keep track of failed targets that were applied before
+			if ref, ok := findTargetRef(previous, targetReference(u)); ok {
+				refs = append(refs, ref)
+			}
+			continue
+		}
+
+		refs = append(refs, targetReference(u))
+	}
 
-		// Todo: More elaborate matchers
-		key := client.ObjectKey{Namespace: dynamicResource.Namespace, Name: trans.FieldFrom.Name}
+	// Delete targets that were applied before, but are no longer rendered
+	retained, err := r.pruneTargets(ctx, c, dynamicResource, previous, targets)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	refs = append(refs, retained...)
+
+	dynamicResource.Status.Targets = refs
+	dynamicResource.Status.TargetRef = nil
+	if len(refs) > 0 {
+		first := refs[0]
+		dynamicResource.Status.TargetRef = &first
+	}
+
+	if len(conflicts) > 0 {
+		setCondition(dynamicResource, dynamickubev1alpha1.ConditionTargetConflict, metav1.ConditionTrue, dynamickubev1alpha1.ReasonAdoptionRefused, utilerrors.NewAggregate(conflicts).Error())
+	} else {
+		setCondition(dynamicResource, dynamickubev1alpha1.ConditionTargetConflict, metav1.ConditionFalse, dynamickubev1alpha1.ReasonNoConflict, "No conflicting targets")
+	}
+
+	if len(errs) > 0 {
+		err := utilerrors.NewAggregate(errs)
+		setCondition(dynamicResource, dynamickubev1alpha1.ConditionTargetApplied, metav1.ConditionFalse, dynamickubev1alpha1.ReasonApplyFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	setCondition(dynamicResource, dynamickubev1alpha1.ConditionTargetApplied, metav1.ConditionTrue, dynamickubev1alpha1.ReasonApplied, "Targets applied")
+
+	// Changes of source objects are picked up through watches, periodic resyncs are optional
+	if dynamicResource.Spec.ResyncInterval != nil {
+		return ctrl.Result{RequeueAfter: dynamicResource.Spec.ResyncInterval.Duration}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileTarget writes a single rendered target to the cluster and reverts drift
+func (r *DynamicResourceReconciler) reconcileTarget(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, u *unstructured.Unstructured) error {
+	logger := log.FromContext(ctx)
+
+	// Get notified about changes of the target object
+	if err := r.watchTarget(u.GroupVersionKind()); err != nil {
+		return errors.WithMessage(err, "Failed to watch target kind")
+	}
 
-		err := r.Get(ctx, key, src)
+	// Detect manual changes of the target that are reverted by applying it
+	if err := setRenderedHash(u); err != nil {
+		return err
+	}
+
+	live, err := r.getTarget(ctx, c, u)
+	if err != nil {
+		return err
+	}
+
+	// Existing targets are only written if the adoption policy allows it
+	if err := r.adoptTarget(ctx, c, dynamicResource, live); err != nil {
+		return err
+	}
+
+	// Compare with the target as the API server would store it, including defaulted fields
+	var desired *unstructured.Unstructured
+	if live != nil {
+		desired = r.normalizeTarget(ctx, c, dynamicResource, u, live)
+	}
+
+	drifted := detectDrift(live, desired)
+
+	op := controllerutil.OperationResultNone
+	if targetUpToDate(dynamicResource, live, desired) {
+		u.SetUID(live.GetUID())
+	} else {
+		op, err = r.applyTarget(ctx, c, dynamicResource, u)
 		if err != nil {
-			//logger.Error(err, "Failed to retrieve fieldFrom source object")
-			return ctrl.Result{}, err
+			return err
+		}
+	}
+
+	if op != controllerutil.OperationResultNone {
+		now := metav1.Now()
+		dynamicResource.Status.LastAppliedTime = &now
+	}
+
+	if len(drifted) > 0 {
+		logger.Info("Reverted drift of target", "resource", client.ObjectKeyFromObject(u), "fields", drifted)
+		r.recorder(ctx).Eventf(dynamicResource, corev1.EventTypeNormal, "DriftCorrected",
+			"Reverted changes of %s %s: %s", u.GetKind(), client.ObjectKeyFromObject(u), strings.Join(drifted, ", "))
+	}
+
+	logger.Info("Dynamic resource reconciled!", "resource", client.ObjectKeyFromObject(u), "operation", op)
+
+	return nil
+}
+
+// getTarget retrieves the live object of the rendered target, or nil if it does not exist
+func (r *DynamicResourceReconciler) getTarget(ctx context.Context, c client.Client, rendered *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(rendered.GroupVersionKind())
+
+	err := c.Get(ctx, client.ObjectKeyFromObject(rendered), live)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return live, nil
+}
+
+// renderTargets builds the target objects from the DynamicResource and resolves all transformations
+func (r *DynamicResourceReconciler) renderTargets(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource) ([]*unstructured.Unstructured, error) {
+	// https://stackoverflow.com/questions/61200605/generic-client-get-for-custom-kubernetes-go-operator
+
+	// Prepare Target objects
+	targets, err := targetObjects(dynamicResource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Define ownership
+	gvk, err := apiutil.GVKForObject(dynamicResource, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range targets {
+		if err := setOwnership(r.RESTMapper(), u, dynamicResource, gvk); err != nil {
+			return nil, err
 		}
+	}
+
+	// Resolve Transformations
+	// All transformations are resolved, so that each failing one is reported in the status
+	statuses := make([]dynamickubev1alpha1.TransformationStatus, 0, len(dynamicResource.Spec.Transformations))
+	var errs []error
 
-		// https://iximiuz.com/en/posts/kubernetes-api-go-types-and-common-machinery/
+	for i, trans := range dynamicResource.Spec.Transformations {
+		status := dynamickubev1alpha1.TransformationStatus{Index: i}
+
+		index, err := targetIndex(targets, trans.Target)
+		if err == nil {
+			status.TargetIndex = index
+			err = r.resolveTransformation(ctx, c, dynamicResource, trans, targets[index], &status)
+		}
 
-		// Parse jsonpath
-		// https://kubernetes.io/docs/reference/kubectl/jsonpath/
-		fields, err := get.RelaxedJSONPathExpression(trans.FieldFrom.FieldSpec)
 		if err != nil {
-			return ctrl.Result{}, errors.WithMessage(err, "Invalid FieldSpec (needs to be a valid jsonpath)")
+			status.Error = err.Error()
+			errs = append(errs, errors.WithMessagef(err, "Transformation %d failed", i))
 		}
 
-		j := jsonpath.New("")
-		err = j.Parse(fields)
+		statuses = append(statuses, status)
+	}
+
+	dynamicResource.Status.Transformations = statuses
+
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+
+	return targets, nil
+}
+
+// resolveTransformation reads the value referenced by a transformation and injects it into the target.
+// The progress of the transformation is recorded in status.
+func (r *DynamicResourceReconciler) resolveTransformation(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, trans dynamickubev1alpha1.DynamicResourceTransformation, u *unstructured.Unstructured, status *dynamickubev1alpha1.TransformationStatus) error {
+	var value interface{}
+
+	encoding := effectiveEncoding(trans, u)
+	decode := encoding == dynamickubev1alpha1.EncodingBase64Decode
+
+	switch {
+	case trans.FieldFrom != nil && trans.Template != "":
+		return errors.New("fieldFrom and template are mutually exclusive")
+
+	case trans.CEL != "" && (trans.FieldFrom != nil || trans.Template != ""):
+		return errors.New("cel is mutually exclusive with fieldFrom and template")
+
+	case trans.FieldFrom != nil:
+		// Handle fieldFrom transfomation
+		if len(trans.Sources) > 0 {
+			return errors.New("sources can only be used with template or cel")
+		}
+		if trans.FieldFrom.ValueType != "" {
+			return errors.New("valueType of fieldFrom is not supported, set the valueType of the transformation instead")
+		}
+
+		var err error
+		value, err = r.resolveFieldRef(ctx, c, dynamicResource, *trans.FieldFrom, trans.ValueType, decode, status)
 		if err != nil {
-			return ctrl.Result{}, errors.WithMessage(err, "Failed to parse FieldSpec (needs to be a valid jsonpath)")
+			return err
 		}
 
-		values, err := j.FindResults(src.Object)
+	case trans.Template != "":
+		// Handle template transformation
+		values, err := r.resolveNamedSources(ctx, c, dynamicResource, trans, status)
 		if err != nil {
-			return ctrl.Result{}, errors.WithMessage(err, "Failed to execute FieldSpec")
+			return err
+		}
 
+		rendered, err := renderTemplate(trans.Template, values)
+		if err != nil {
+			return err
+		}
+		if transformationSensitive(trans) {
+			sensitive.FromContext(ctx).Track(rendered)
 		}
 
-		// Allow only single-result jsonpaths
-		var data string
+		if decode {
+			rendered, err = base64Decode(rendered)
+			if err != nil {
+				return err
+			}
+		}
 
-		if len(values) == 0 {
-			return ctrl.Result{}, errors.New(fmt.Sprintf("JSONPath '%s' did not yield any result", trans.FieldFrom.FieldSpec))
-		} else if len(values) > 1 {
-			return ctrl.Result{}, errors.New(fmt.Sprintf("JSONPath '%s' yield '%d' result", trans.FieldFrom.FieldSpec, len(values)))
-		} else {
-			buf := &bytes.Buffer{}
-			err = j.PrintResults(buf, values[0])
+		value = rendered
+		if trans.ValueType != dynamickubev1alpha1.ValueTypeString && trans.ValueType != "" {
+			value, err = convertValue(rendered, trans.ValueType)
 			if err != nil {
-				return ctrl.Result{}, err
+				return err
 			}
+		}
+
+	case trans.CEL != "":
+		// Handle CEL transformation
+		values, err := r.resolveNamedSources(ctx, c, dynamicResource, trans, status)
+		if err != nil {
+			return err
+		}
 
-			data = buf.String()
+		program, err := r.programs.program(dynamicResource, status.Index, trans)
+		if err != nil {
+			return err
 		}
 
-		// Inject into target field
-		spec := strings.Split(trans.TargetField, ".")
-		err = unstructured.SetNestedField(u.Object, data, spec...)
+		result, err := evaluateCEL(program, values)
 		if err != nil {
-			return ctrl.Result{}, err
+			return err
+		}
+		if transformationSensitive(trans) {
+			sensitive.FromContext(ctx).Track(result)
+		}
+
+		value, err = convertCELResult(result, trans.ValueType, decode)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return errors.New("one of fieldFrom, template or cel is required")
+	}
+
+	if encoding == dynamickubev1alpha1.EncodingBase64Encode {
+		var err error
+		value, err = base64Encode(value)
+		if err != nil {
+			return err
 		}
 	}
 
-	err = r.Update(ctx, u)
+	if transformationSensitive(trans) {
+		sensitive.FromContext(ctx).Track(value)
+	}
+
+	status.Resolved = true
+
+	// Inject into target field
+	path, err := injectValue(u, trans, value)
 	if err != nil {
-		return ctrl.Result{RequeueAfter: 10 * time.Second}, err
+		return err
 	}
 
-	logger.Info("Dynamic resource reconciled!", "resource", client.ObjectKeyFromObject(u))
+	status.TargetPath = path
 
-	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	return nil
+}
+
+// resolveNamedSources reads the values of the named sources of a template or CEL transformation,
+// converted to their valueType. Values of sources without valueType keep their native type.
+func (r *DynamicResourceReconciler) resolveNamedSources(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, trans dynamickubev1alpha1.DynamicResourceTransformation, status *dynamickubev1alpha1.TransformationStatus) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, name := range sortedSourceNames(trans.Sources) {
+		ref := trans.Sources[name]
+
+		valueType := ref.ValueType
+		if valueType == "" {
+			valueType = dynamickubev1alpha1.ValueTypeAuto
+		}
+
+		v, err := r.resolveFieldRef(ctx, c, dynamicResource, ref, valueType, false, status)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Source '%s'", name)
+		}
+		values[name] = v
+	}
+
+	return values, nil
+}
+
+// resolveFieldRef reads the value of the field referenced by ref, converted to valueType.
+// Base64 encoded values are decoded before the conversion if decode is set.
+// The source objects that were read are recorded in status.
+func (r *DynamicResourceReconciler) resolveFieldRef(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, ref dynamickubev1alpha1.ExternalFieldRef, valueType dynamickubev1alpha1.ValueType, decode bool, status *dynamickubev1alpha1.TransformationStatus) (interface{}, error) {
+	sources, err := r.resolveSources(ctx, c, dynamicResource, ref)
+	if err != nil {
+		//logger.Error(err, "Failed to retrieve fieldFrom source object")
+		return nil, err
+	}
+
+	for _, src := range sources {
+		status.Sources = append(status.Sources, dynamickubev1alpha1.SourceReference{
+			APIVersion:      src.GetAPIVersion(),
+			Kind:            src.GetKind(),
+			Namespace:       src.GetNamespace(),
+			Name:            src.GetName(),
+			UID:             src.GetUID(),
+			ResourceVersion: src.GetResourceVersion(),
+		})
+	}
+
+	// https://iximiuz.com/en/posts/kubernetes-api-go-types-and-common-machinery/
+
+	j, err := transform.ParseFieldSpec(ref.FieldSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(sources))
+	for _, src := range sources {
+		if isSensitive(ref) {
+			trackResults(sensitive.FromContext(ctx), j, src)
+		}
+
+		data, err := extractField(j, ref.FieldSpec, src, valueType, decode)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, data)
+	}
+
+	// Values of all matched sources are injected as a list
+	if ref.Pick == dynamickubev1alpha1.PickAll {
+		return values, nil
+	}
+
+	return values[0], nil
+}
+
+// extractField executes the parsed FieldSpec on a source object, which needs to yield exactly one result.
+// The result is base64 decoded if decode is set and converted to the given value type.
+func extractField(j *jsonpath.JSONPath, fieldSpec string, src *unstructured.Unstructured, valueType dynamickubev1alpha1.ValueType, decode bool) (interface{}, error) {
+	values, err := j.FindResults(src.Object)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to execute FieldSpec")
+
+	}
+
+	// Allow only single-result jsonpaths
+	if len(values) == 0 {
+		return nil, errors.New(fmt.Sprintf("JSONPath '%s' did not yield any result", fieldSpec))
+	} else if len(values) > 1 {
+		return nil, errors.New(fmt.Sprintf("JSONPath '%s' yield '%d' result", fieldSpec, len(values)))
+	}
+
+	// Strings are rendered like kubectl does
+	if valueType == dynamickubev1alpha1.ValueTypeString || valueType == "" {
+		buf := &bytes.Buffer{}
+		err = j.PrintResults(buf, values[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if decode {
+			return base64Decode(buf.String())
+		}
+
+		return buf.String(), nil
+	}
+
+	// Typed values need to originate from exactly one node
+	if len(values[0]) != 1 {
+		return nil, errors.New(fmt.Sprintf("JSONPath '%s' yield '%d' values, but value type '%s' requires exactly one", fieldSpec, len(values[0]), valueType))
+	}
+
+	value := values[0][0].Interface()
+	if decode {
+		value, err = base64Decode(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return convertValue(value, valueType)
+}
+
+// setCondition sets a condition on the status of the DynamicResource for its current generation
+func setCondition(dynamicResource *dynamickubev1alpha1.DynamicResource, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&dynamicResource.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: dynamicResource.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// applyTarget writes the rendered target according to the apply strategy of the DynamicResource
+func (r *DynamicResourceReconciler) applyTarget(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, rendered *unstructured.Unstructured) (controllerutil.OperationResult, error) {
+	switch dynamicResource.Spec.ApplyStrategy {
+	case dynamickubev1alpha1.ApplyStrategyServerSideApply:
+		return r.serverSideApplyTarget(ctx, c, rendered, dynamicResource.Spec.ForceConflicts)
+	case dynamickubev1alpha1.ApplyStrategyUpdate, "":
+		return r.createOrUpdateTarget(ctx, c, rendered)
+	default:
+		return controllerutil.OperationResultNone, errors.New(fmt.Sprintf("Unknown apply strategy '%s'", dynamicResource.Spec.ApplyStrategy))
+	}
+}
+
+// serverSideApplyTarget applies the rendered target using server-side apply. Only the
+// fields present in the rendered object are owned by the controller's field manager.
+func (r *DynamicResourceReconciler) serverSideApplyTarget(ctx context.Context, c client.Client, rendered *unstructured.Unstructured, force bool) (controllerutil.OperationResult, error) {
+	// Retrieve the live target to tell whether the apply changed anything
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(rendered.GroupVersionKind())
+
+	err := c.Get(ctx, client.ObjectKeyFromObject(rendered), existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return controllerutil.OperationResultNone, err
+	}
+	found := err == nil
+
+	target := rendered.DeepCopy()
+
+	opts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if force {
+		opts = append(opts, client.ForceOwnership)
+	}
+
+	if err := c.Patch(ctx, target, client.Apply, opts...); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	rendered.SetUID(target.GetUID())
+
+	if !found {
+		return controllerutil.OperationResultCreated, nil
+	} else if target.GetResourceVersion() != existing.GetResourceVersion() {
+		return controllerutil.OperationResultUpdated, nil
+	}
+
+	return controllerutil.OperationResultNone, nil
+}
+
+// serverManagedFields are populated by the API server and are carried over from
+// the live target, so that unchanged targets do not result in an update
+var serverManagedFields = [][]string{
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "managedFields"},
+	{"metadata", "selfLink"},
+	{"status"},
+}
+
+// createOrUpdateTarget creates the rendered target if it does not exist yet and
+// updates it otherwise. The live resourceVersion is carried over to the rendered
+// object, so that updates are subject to optimistic concurrency control.
+func (r *DynamicResourceReconciler) createOrUpdateTarget(ctx context.Context, c client.Client, rendered *unstructured.Unstructured) (controllerutil.OperationResult, error) {
+	target := &unstructured.Unstructured{}
+	target.SetGroupVersionKind(rendered.GroupVersionKind())
+	target.SetNamespace(rendered.GetNamespace())
+	target.SetName(rendered.GetName())
+
+	op, err := controllerutil.CreateOrUpdate(ctx, c, target, func() error {
+		desired, err := withServerManagedFields(rendered, target)
+		if err != nil {
+			return err
+		}
+
+		target.SetUnstructuredContent(desired.Object)
+
+		return nil
+	})
+	if err != nil {
+		return op, err
+	}
+
+	rendered.SetUID(target.GetUID())
+
+	return op, nil
+}
+
+// withServerManagedFields returns a copy of the rendered target with the server managed fields of the live target
+func withServerManagedFields(rendered, live *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	desired := rendered.DeepCopy()
+
+	for _, path := range serverManagedFields {
+		value, found, err := unstructured.NestedFieldNoCopy(live.Object, path...)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			if err := unstructured.SetNestedField(desired.Object, runtime.DeepCopyJSONValue(value), path...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return desired, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DynamicResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &dynamickubev1alpha1.DynamicResource{}, sourceIndexKey, r.indexSources)
+	if err != nil {
+		return err
+	}
+
+	r.config = mgr.GetConfig()
+	r.targetWatches = map[schema.GroupVersionKind]struct{}{}
+	r.programs = newCELPrograms()
+
+	// Source objects are read from informers that also notify about their changes
+	r.sources, err = newSourceInformers(mgr.GetConfig(), mgr.GetRESTMapper(), r.watchSource, r.requiredProjection)
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(r.sources); err != nil {
+		return err
+	}
+
+	r.controller, err = ctrl.NewControllerManagedBy(mgr).
 		For(&dynamickubev1alpha1.DynamicResource{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &dynamickubev1alpha1.DynamicResourcePolicy{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForPolicy)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Build(r)
+
+	return err
 }
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubectl/pkg/cmd/get"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// secretGVK identifies core Secrets, whose data is base64 encoded
+var secretGVK = schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+
+// effectiveEncoding returns the encoding to apply for a transformation writing to target
+func effectiveEncoding(trans dynamickubev1alpha1.DynamicResourceTransformation, target *unstructured.Unstructured) dynamickubev1alpha1.Encoding {
+	if trans.Encoding != dynamickubev1alpha1.EncodingAuto && trans.Encoding != "" {
+		return trans.Encoding
+	}
+
+	fromSecretData := trans.FieldFrom != nil && readsSecretData(*trans.FieldFrom)
+	toSecretData := target.GroupVersionKind() == secretGVK && writesData(trans)
+
+	switch {
+	case fromSecretData && !toSecretData:
+		return dynamickubev1alpha1.EncodingBase64Decode
+	case !fromSecretData && toSecretData:
+		return dynamickubev1alpha1.EncodingBase64Encode
+	default:
+		return dynamickubev1alpha1.EncodingNone
+	}
+}
+
+// readsSecretData checks whether ref reads a field below .data of a Secret
+func readsSecretData(ref dynamickubev1alpha1.ExternalFieldRef) bool {
+	if schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind) != secretGVK {
+		return false
+	}
+
+	fields, err := get.RelaxedJSONPathExpression(ref.FieldSpec)
+	if err != nil {
+		return false
+	}
+
+	fields = strings.TrimSuffix(strings.TrimPrefix(fields, "{"), "}")
+
+	return strings.HasPrefix(fields, ".data.") || strings.HasPrefix(fields, ".data[")
+}
+
+// writesData checks whether the transformation writes a field below .data of the target
+func writesData(trans dynamickubev1alpha1.DynamicResourceTransformation) bool {
+	return strings.HasPrefix(trans.TargetField, "data.") || strings.HasPrefix(trans.TargetPath, "/data/")
+}
+
+// base64Decode decodes a base64 encoded string value
+func base64Decode(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Cannot base64 decode value of type %T", value))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", errors.WithMessage(err, "Failed to base64 decode value")
+	}
+
+	return string(decoded), nil
+}
+
+// base64Encode encodes a string value or each item of a list of strings
+func base64Encode(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	case []interface{}:
+		encoded := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			e, err := base64Encode(item)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, e)
+		}
+		return encoded, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Cannot base64 encode value of type %T", value))
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestEffectiveEncoding(t *testing.T) {
+	secret := newTestObject("v1", "Secret", "default", "target")
+	configMap := newTestObject("v1", "ConfigMap", "default", "target")
+
+	secretData := newTestFieldRef("v1", "Secret", "source", "{.data.password}")
+	secretMeta := newTestFieldRef("v1", "Secret", "source", "{.metadata.name}")
+	configMapData := newTestFieldRef("v1", "ConfigMap", "source", "{.data.password}")
+
+	tests := []struct {
+		name     string
+		trans    dynamickubev1alpha1.DynamicResourceTransformation
+		target   *unstructured.Unstructured
+		expected dynamickubev1alpha1.Encoding
+	}{
+		{
+			name:     "Secret data to ConfigMap data",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetField: "data.password"},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingBase64Decode,
+		},
+		{
+			name:     "Secret data to Secret data",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetField: "data.password"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			name:     "Secret data to Secret stringData",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetField: "stringData.password"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Decode,
+		},
+		{
+			name:     "Secret data to Secret data by targetPath",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetPath: "/data/password"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			name:     "Secret metadata to Secret data",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretMeta, TargetField: "data.name"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Encode,
+		},
+		{
+			name:     "ConfigMap data to Secret data",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: configMapData, TargetField: "data.password"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Encode,
+		},
+		{
+			name:     "ConfigMap data to Secret data by targetPath",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: configMapData, TargetPath: "/data/tls.crt"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Encode,
+		},
+		{
+			name:     "ConfigMap data to ConfigMap data",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: configMapData, TargetField: "data.password"},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			name:     "ConfigMap data to Secret metadata",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: configMapData, TargetPath: "/metadata/annotations/password"},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			// Template sources are passed as they are, the rendered value is encoded for Secret data
+			name: "template to Secret data",
+			trans: dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"password": *secretData},
+				Template:    "{{ .password | b64dec }}",
+				TargetField: "data.password",
+			},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Encode,
+		},
+		{
+			name: "template to Secret data by targetPath",
+			trans: dynamickubev1alpha1.DynamicResourceTransformation{
+				Template:   "static",
+				TargetPath: "/data/config",
+			},
+			target:   secret,
+			expected: dynamickubev1alpha1.EncodingBase64Encode,
+		},
+		{
+			name: "template to ConfigMap data",
+			trans: dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"password": *secretData},
+				Template:    "{{ .password }}",
+				TargetField: "data.password",
+			},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			name:     "explicit None",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetField: "data.password", Encoding: dynamickubev1alpha1.EncodingNone},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingNone,
+		},
+		{
+			name:     "explicit Base64Decode",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: configMapData, TargetField: "data.password", Encoding: dynamickubev1alpha1.EncodingBase64Decode},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingBase64Decode,
+		},
+		{
+			name:     "explicit Auto",
+			trans:    dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretData, TargetField: "data.password", Encoding: dynamickubev1alpha1.EncodingAuto},
+			target:   configMap,
+			expected: dynamickubev1alpha1.EncodingBase64Decode,
+		},
+	}
+
+	for _, test := range tests {
+		if encoding := effectiveEncoding(test.trans, test.target); encoding != test.expected {
+			t.Errorf("%s: expected encoding %s, got %s", test.name, test.expected, encoding)
+		}
+	}
+}
+
+func TestReadsSecretData(t *testing.T) {
+	tests := []struct {
+		ref      *dynamickubev1alpha1.ExternalFieldRef
+		expected bool
+	}{
+		{newTestFieldRef("v1", "Secret", "source", "{.data.password}"), true},
+		{newTestFieldRef("v1", "Secret", "source", ".data.password"), true},
+		{newTestFieldRef("v1", "Secret", "source", `{.data['tls\.crt']}`), true},
+		{newTestFieldRef("v1", "Secret", "source", "{.data}"), false},
+		{newTestFieldRef("v1", "Secret", "source", "{.stringData.password}"), false},
+		{newTestFieldRef("v1", "Secret", "source", "{.metadata.labels.data}"), false},
+		{newTestFieldRef("v1", "ConfigMap", "source", "{.data.password}"), false},
+		{newTestFieldRef("example.com/v1", "Secret", "source", "{.data.password}"), false},
+	}
+
+	for _, test := range tests {
+		if reads := readsSecretData(*test.ref); reads != test.expected {
+			t.Errorf("%s %s: expected %t, got %t", test.ref.Kind, test.ref.FieldSpec, test.expected, reads)
+		}
+	}
+}
+
+func TestWritesData(t *testing.T) {
+	tests := []struct {
+		trans    dynamickubev1alpha1.DynamicResourceTransformation
+		expected bool
+	}{
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "data.password"}, true},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetPath: "/data/tls.crt"}, true},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetPath: "/data/app.kubernetes.io~1name"}, true},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "data"}, false},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetPath: "/data"}, false},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "stringData.password"}, false},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetPath: "/metadata/labels/data"}, false},
+		{dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "metadata.data.password"}, false},
+	}
+
+	for _, test := range tests {
+		if writes := writesData(test.trans); writes != test.expected {
+			t.Errorf("%s%s: expected %t, got %t", test.trans.TargetField, test.trans.TargetPath, test.expected, writes)
+		}
+	}
+}
+
+func TestBase64(t *testing.T) {
+	encoded, err := base64Encode([]interface{}{"user", "pass"})
+	if err != nil || !reflect.DeepEqual(encoded, []interface{}{"dXNlcg==", "cGFzcw=="}) {
+		t.Errorf("unexpected result %v, %v", encoded, err)
+	}
+	if _, err := base64Encode(int64(1)); err == nil {
+		t.Errorf("expected error encoding an int")
+	}
+
+	decoded, err := base64Decode("cGFzcw==")
+	if err != nil || decoded != "pass" {
+		t.Errorf("unexpected result %q, %v", decoded, err)
+	}
+	if _, err := base64Decode("not base64!"); err == nil {
+		t.Errorf("expected error decoding an invalid value")
+	}
+	if _, err := base64Decode(int64(1)); err == nil {
+		t.Errorf("expected error decoding an int")
+	}
+}
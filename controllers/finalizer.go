@@ -0,0 +1,107 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// Finalizer ensures the deletion policy is enforced before a DynamicResource is removed
+const Finalizer = "dynamic.kube/finalizer"
+
+// finalize handles the targets of a deleted DynamicResource according to its deletion policy
+// and removes the finalizer once all targets have been handled
+func (r *DynamicResourceReconciler) finalize(ctx context.Context, dynamicResource *dynamickubev1alpha1.DynamicResource) error {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(dynamicResource, Finalizer) {
+		return nil
+	}
+
+	var errs []error
+	for _, ref := range dynamicResource.Status.Targets {
+		if err := r.finalizeTarget(ctx, dynamicResource, ref); err != nil {
+			errs = append(errs, errors.WithMessagef(err, "Failed to finalize %s %s/%s", ref.Kind, ref.Namespace, ref.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	logger.Info("Finalized targets", "deletionPolicy", dynamicResource.Spec.DeletionPolicy)
+
+	controllerutil.RemoveFinalizer(dynamicResource, Finalizer)
+
+	return r.Update(ctx, dynamicResource)
+}
+
+// finalizeTarget deletes or detaches a single target according to the deletion policy
+func (r *DynamicResourceReconciler) finalizeTarget(ctx context.Context, dynamicResource *dynamickubev1alpha1.DynamicResource, ref dynamickubev1alpha1.TargetReference) error {
+	live := &unstructured.Unstructured{}
+	live.SetAPIVersion(ref.APIVersion)
+	live.SetKind(ref.Kind)
+
+	err := r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, live)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Targets taken over by someone else are left alone
+	if !controlledBy(live, dynamicResource) {
+		return nil
+	}
+
+	switch dynamicResource.Spec.DeletionPolicy {
+	case dynamickubev1alpha1.DeletionPolicyDelete, "":
+		uid := live.GetUID()
+		return client.IgnoreNotFound(r.Delete(ctx, live, client.Preconditions{UID: &uid}))
+
+	case dynamickubev1alpha1.DeletionPolicyOrphan:
+		patch := client.MergeFrom(live.DeepCopy())
+		removeOwnership(live, dynamicResource)
+
+		annotations := live.GetAnnotations()
+		delete(annotations, renderedHashAnnotation)
+		delete(annotations, dynamickubev1alpha1.OwnerAnnotation)
+		live.SetAnnotations(annotations)
+
+		return r.Patch(ctx, live, patch)
+
+	case dynamickubev1alpha1.DeletionPolicyRetain:
+		patch := client.MergeFrom(live.DeepCopy())
+		removeOwnership(live, dynamicResource)
+
+		return r.Patch(ctx, live, patch)
+
+	default:
+		return errors.New(fmt.Sprintf("Unknown deletion policy '%s'", dynamicResource.Spec.DeletionPolicy))
+	}
+}
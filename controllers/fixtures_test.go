@@ -0,0 +1,101 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// newTestObject returns an object of the kind with the name, in the namespace unless it is empty
+func newTestObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+
+	return u
+}
+
+// newTestFieldRef returns a reference to the field of the named source object of the kind
+func newTestFieldRef(apiVersion, kind, name, fieldSpec string) *dynamickubev1alpha1.ExternalFieldRef {
+	return &dynamickubev1alpha1.ExternalFieldRef{
+		TypeMeta:  metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		Name:      name,
+		FieldSpec: fieldSpec,
+	}
+}
+
+// newTestScheme returns a scheme with the core kinds and the kinds of this controller
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = dynamickubev1alpha1.AddToScheme(scheme)
+
+	return scheme
+}
+
+// newTestRESTMapper returns a static RESTMapper for namespaced Secrets and ConfigMaps
+// and cluster-scoped Namespaces and ClusterRoles
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+
+	return mapper
+}
+
+// newTestReconciler returns a reconciler backed by a fake client holding the objects.
+// Events are recorded by a fake recorder.
+func newTestReconciler(objs ...client.Object) *DynamicResourceReconciler {
+	scheme := newTestScheme()
+
+	return &DynamicResourceReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(newTestRESTMapper()).WithObjects(objs...).Build(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// newTestDynamicResource returns a DynamicResource in the default namespace, that renders the target with the transformations
+func newTestDynamicResource(name string, target *unstructured.Unstructured, transformations ...dynamickubev1alpha1.DynamicResourceTransformation) *dynamickubev1alpha1.DynamicResource {
+	return &dynamickubev1alpha1.DynamicResource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name + "-uid")},
+		Spec: dynamickubev1alpha1.DynamicResourceSpec{
+			Target:          target,
+			Transformations: transformations,
+		},
+	}
+}
+
+// boolPtr returns a pointer to b
+func boolPtr(b bool) *bool {
+	return &b
+}
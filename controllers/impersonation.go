@@ -0,0 +1,51 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// clientFor returns the client to read the sources and write the targets of the DynamicResource with.
+// DynamicResources with a ServiceAccount get a client impersonating it, all others use the controller's client.
+func (r *DynamicResourceReconciler) clientFor(dynamicResource *dynamickubev1alpha1.DynamicResource) (client.Client, error) {
+	name := dynamicResource.Spec.ServiceAccountName
+	if name == "" {
+		if r.RequireServiceAccount {
+			return nil, errors.New("spec.serviceAccountName is required")
+		}
+		return r.Client, nil
+	}
+
+	config := rest.CopyConfig(r.config)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", dynamicResource.Namespace, name),
+	}
+
+	c, err := client.New(config, client.Options{Scheme: r.Scheme, Mapper: r.RESTMapper()})
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to create client for ServiceAccount")
+	}
+
+	return c, nil
+}
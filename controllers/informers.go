@@ -0,0 +1,515 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// informerSyncTimeout bounds the time a reconciliation waits for a new source informer to sync
+const informerSyncTimeout = 30 * time.Second
+
+// sourceInformer is an informer holding the projected objects of a single source kind
+type sourceInformer struct {
+	informer   toolscache.SharedIndexInformer
+	reader     *informerReader
+	projection projection
+	cancel     context.CancelFunc
+
+	// size is the estimated size of the cached objects in bytes. It is only reported as metric once the
+	// informer is published, so that a replaced informer does not report the objects of its successor.
+	sizeLock  sync.Mutex
+	size      float64
+	published bool
+}
+
+// addSize adds delta to the size of the cached objects and reports it if the informer is published
+func (i *sourceInformer) addSize(gvk schema.GroupVersionKind, delta float64) {
+	i.sizeLock.Lock()
+	defer i.sizeLock.Unlock()
+
+	i.size += delta
+	if i.published {
+		cachedSourceBytes.WithLabelValues(gvk.String()).Set(i.size)
+	}
+}
+
+// publish starts reporting the size of the cached objects
+func (i *sourceInformer) publish(gvk schema.GroupVersionKind) {
+	i.sizeLock.Lock()
+	defer i.sizeLock.Unlock()
+
+	i.published = true
+	cachedSourceBytes.WithLabelValues(gvk.String()).Set(i.size)
+}
+
+// unpublish stops reporting the size of the cached objects
+func (i *sourceInformer) unpublish(gvk schema.GroupVersionKind) {
+	i.sizeLock.Lock()
+	defer i.sizeLock.Unlock()
+
+	i.published = false
+	cachedSourceBytes.DeleteLabelValues(gvk.String())
+}
+
+// sourceInformers serves source reads from shared informer caches, one per source kind.
+// Informers are started on first use and stopped once no DynamicResource reads the kind anymore.
+// Only the fields read by DynamicResources are cached, kinds of which only metadata is read are
+// served from metadata-only informers.
+type sourceInformers struct {
+	dynamic  dynamic.Interface
+	metadata metadata.Interface
+	mapper   meta.RESTMapper
+
+	// onStart is called with every newly started informer, e.g. to watch its events
+	onStart func(informer cache.Informer) error
+
+	// required returns the fields of the kind read by all DynamicResources, including those that did not reconcile yet
+	required func(ctx context.Context, gvk schema.GroupVersionKind) (projection, error)
+
+	lock      sync.Mutex
+	informers map[schema.GroupVersionKind]*sourceInformer
+	users     map[types.NamespacedName]map[schema.GroupVersionKind]projection
+	stopped   bool
+
+	// pending holds the kinds of which an informer is being started, the channels are closed once it synced
+	pending map[schema.GroupVersionKind]chan struct{}
+}
+
+// newSourceInformers creates an empty set of source informers
+func newSourceInformers(config *rest.Config, mapper meta.RESTMapper, onStart func(informer cache.Informer) error,
+	required func(ctx context.Context, gvk schema.GroupVersionKind) (projection, error)) (*sourceInformers, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sourceInformers{
+		dynamic:   dynamicClient,
+		metadata:  metadataClient,
+		mapper:    mapper,
+		onStart:   onStart,
+		required:  required,
+		informers: map[schema.GroupVersionKind]*sourceInformer{},
+		users:     map[types.NamespacedName]map[schema.GroupVersionKind]projection{},
+		pending:   map[schema.GroupVersionKind]chan struct{}{},
+	}, nil
+}
+
+// reader returns a reader for objects of the given kind on behalf of the DynamicResource, that keeps at least
+// the fields of the projection. An informer for the kind is started if there is none yet, informers that do
+// not keep all required fields are replaced.
+// New informers keep the fields read by all DynamicResources, not only by those that have been reconciled,
+// so that informers are not replaced over and over while the DynamicResources are reconciled the first time.
+// Informers are started and synced without holding the lock, so that reads of other kinds are not blocked.
+// Concurrent reads of a kind wait for the informer being started, the replaced informer is stopped once the
+// new one has synced.
+func (s *sourceInformers) reader(ctx context.Context, user types.NamespacedName, gvk schema.GroupVersionKind, fields projection) (client.Reader, error) {
+	s.lock.Lock()
+
+	if s.users[user] == nil {
+		s.users[user] = map[schema.GroupVersionKind]projection{}
+	}
+	s.users[user][gvk] = fields
+
+	for {
+		if informer, ok := s.informers[gvk]; ok && informer.projection.covers(fields) {
+			s.lock.Unlock()
+			return informer.reader, nil
+		}
+
+		pending, ok := s.pending[gvk]
+		if !ok {
+			break
+		}
+
+		s.lock.Unlock()
+		select {
+		case <-pending:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.lock.Lock()
+	}
+
+	var required projection
+	for _, projections := range s.users {
+		if p, ok := projections[gvk]; ok {
+			required.merge(p)
+		}
+	}
+
+	done := make(chan struct{})
+	s.pending[gvk] = done
+	s.lock.Unlock()
+
+	informer, err := s.startRequired(ctx, gvk, required)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.pending, gvk)
+	close(done)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The informer is not needed anymore if the DynamicResources reading the kind were removed
+	// or the manager shut down while it was synced. It still serves the current read.
+	if s.stopped || !s.used(gvk) {
+		informer.cancel()
+		return informer.reader, nil
+	}
+
+	if _, ok := s.informers[gvk]; ok {
+		s.stop(ctx, gvk)
+	}
+	s.informers[gvk] = informer
+	informer.publish(gvk)
+	activeSourceInformers.Set(float64(len(s.informers)))
+
+	return informer.reader, nil
+}
+
+// startRequired starts an informer for the kind, that keeps the fields read by all DynamicResources and the given fields
+func (s *sourceInformers) startRequired(ctx context.Context, gvk schema.GroupVersionKind, fields projection) (*sourceInformer, error) {
+	required, err := s.required(ctx, gvk)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to determine fields of source kind")
+	}
+	required.merge(fields)
+
+	return s.start(ctx, gvk, required)
+}
+
+// start creates an informer for the kind and waits for it to sync
+func (s *sourceInformers) start(ctx context.Context, gvk schema.GroupVersionKind, fields projection) (*sourceInformer, error) {
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to determine resource of source kind")
+	}
+
+	informer := toolscache.NewSharedIndexInformer(s.listWatch(gvk, mapping.Resource, fields), &unstructured.Unstructured{}, 0,
+		toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+
+	// The informer outlives the reconciliation that started it
+	informerCtx, cancel := context.WithCancel(context.Background())
+
+	started := &sourceInformer{
+		informer:   informer,
+		reader:     &informerReader{indexer: informer.GetIndexer(), resource: mapping.Resource.GroupResource()},
+		projection: fields,
+		cancel:     cancel,
+	}
+
+	// Keep track of the memory used by the cached objects
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			started.addSize(gvk, objectSize(obj))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			started.addSize(gvk, objectSize(newObj)-objectSize(oldObj))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			started.addSize(gvk, -objectSize(obj))
+		},
+	})
+
+	go informer.Run(informerCtx.Done())
+
+	syncCtx, syncCancel := context.WithTimeout(ctx, informerSyncTimeout)
+	defer syncCancel()
+
+	if !toolscache.WaitForCacheSync(syncCtx.Done(), informer.HasSynced) {
+		cancel()
+		return nil, errors.New("Timed out waiting for source informer to sync")
+	}
+
+	if err := s.onStart(informer); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	log.FromContext(ctx).Info("Started source informer", "kind", gvk, "metadataOnly", fields.metadataOnly())
+
+	return started, nil
+}
+
+// listWatch lists and watches the objects of a kind across all namespaces and projects them before they are cached.
+// Kinds of which only metadata is read are retrieved as PartialObjectMetadata.
+func (s *sourceInformers) listWatch(gvk schema.GroupVersionKind, resource schema.GroupVersionResource, fields projection) *toolscache.ListWatch {
+	project := func(obj runtime.Object) (runtime.Object, error) {
+		var content map[string]interface{}
+
+		switch o := obj.(type) {
+		case *unstructured.Unstructured:
+			content = o.Object
+		case *metav1.PartialObjectMetadata:
+			var err error
+			if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(o); err != nil {
+				return nil, err
+			}
+		default:
+			return obj, nil
+		}
+
+		u := &unstructured.Unstructured{Object: content}
+		u.SetGroupVersionKind(gvk)
+		u.Object = fields.apply(u.Object)
+
+		return u, nil
+	}
+
+	projectWatch := func(w watch.Interface) watch.Interface {
+		return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+			obj, err := project(event.Object)
+			if err != nil {
+				return event, false
+			}
+			event.Object = obj
+			return event, true
+		})
+	}
+
+	if fields.metadataOnly() {
+		client := s.metadata.Resource(resource)
+
+		return &toolscache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				list, err := client.List(context.Background(), options)
+				if err != nil {
+					return nil, err
+				}
+
+				projected := &unstructured.UnstructuredList{}
+				projected.SetResourceVersion(list.GetResourceVersion())
+				projected.SetContinue(list.GetContinue())
+				for i := range list.Items {
+					obj, err := project(&list.Items[i])
+					if err != nil {
+						return nil, err
+					}
+					projected.Items = append(projected.Items, *obj.(*unstructured.Unstructured))
+				}
+
+				return projected, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				w, err := client.Watch(context.Background(), options)
+				if err != nil {
+					return nil, err
+				}
+				return projectWatch(w), nil
+			},
+		}
+	}
+
+	client := s.dynamic.Resource(resource)
+
+	return &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := client.List(context.Background(), options)
+			if err != nil {
+				return nil, err
+			}
+
+			for i := range list.Items {
+				list.Items[i].Object = fields.apply(list.Items[i].Object)
+			}
+
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := client.Watch(context.Background(), options)
+			if err != nil {
+				return nil, err
+			}
+			return projectWatch(w), nil
+		},
+	}
+}
+
+// stop stops the informer of the kind. The caller must hold the lock.
+func (s *sourceInformers) stop(ctx context.Context, gvk schema.GroupVersionKind) {
+	s.informers[gvk].cancel()
+	s.informers[gvk].unpublish(gvk)
+	delete(s.informers, gvk)
+
+	log.FromContext(ctx).Info("Stopped source informer", "kind", gvk)
+}
+
+// retain drops all kinds not in gvks from the kinds read by the DynamicResource
+// and stops the informers that are no longer used by any DynamicResource
+func (s *sourceInformers) retain(ctx context.Context, user types.NamespacedName, gvks map[schema.GroupVersionKind]struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for gvk := range s.users[user] {
+		if _, ok := gvks[gvk]; !ok {
+			delete(s.users[user], gvk)
+		}
+	}
+	if len(s.users[user]) == 0 {
+		delete(s.users, user)
+	}
+
+	for gvk := range s.informers {
+		if !s.used(gvk) {
+			s.stop(ctx, gvk)
+		}
+	}
+
+	activeSourceInformers.Set(float64(len(s.informers)))
+}
+
+// used checks whether any DynamicResource reads the kind
+func (s *sourceInformers) used(gvk schema.GroupVersionKind) bool {
+	for _, projections := range s.users {
+		if _, ok := projections[gvk]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start implements manager.Runnable and stops all informers when the manager shuts down
+func (s *sourceInformers) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Informers that are being started are stopped once they synced
+	s.stopped = true
+
+	for gvk := range s.informers {
+		s.stop(ctx, gvk)
+	}
+
+	activeSourceInformers.Set(0)
+
+	return nil
+}
+
+// objectSize estimates the memory used by a cached object by the size of its JSON encoding
+func objectSize(obj interface{}) float64 {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return 0
+	}
+
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return 0
+	}
+
+	return float64(len(data))
+}
+
+// informerReader reads unstructured objects from the store of an informer
+type informerReader struct {
+	indexer  toolscache.Indexer
+	resource schema.GroupResource
+}
+
+// Get implements client.Reader
+func (r *informerReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return errors.New(fmt.Sprintf("Source informers only serve unstructured objects, got %T", obj))
+	}
+
+	storeKey := key.Name
+	if key.Namespace != "" {
+		storeKey = key.Namespace + "/" + key.Name
+	}
+
+	item, exists, err := r.indexer.GetByKey(storeKey)
+	if err != nil {
+		return err
+	} else if !exists {
+		return apierrors.NewNotFound(r.resource, key.Name)
+	}
+
+	u.Object = item.(*unstructured.Unstructured).DeepCopy().Object
+
+	return nil
+}
+
+// List implements client.Reader, only the namespace and label selector options are supported
+func (r *informerReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	ul, ok := list.(*unstructured.UnstructuredList)
+	if !ok {
+		return errors.New(fmt.Sprintf("Source informers only serve unstructured lists, got %T", list))
+	}
+
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	var items []interface{}
+	if listOpts.Namespace != "" {
+		var err error
+		if items, err = r.indexer.ByIndex(toolscache.NamespaceIndex, listOpts.Namespace); err != nil {
+			return err
+		}
+	} else {
+		items = r.indexer.List()
+	}
+
+	ul.Items = nil
+	for _, item := range items {
+		u := item.(*unstructured.Unstructured)
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(u.GetLabels())) {
+			continue
+		}
+		ul.Items = append(ul.Items, *u.DeepCopy())
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestSourceInformersReaderStartsOnce(t *testing.T) {
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+	}
+
+	r := newTestReconciler()
+	informers := newTestSourceInformers(r, secret)
+
+	var lock sync.Mutex
+	started := 0
+	informers.onStart = func(cache.Informer) error {
+		lock.Lock()
+		defer lock.Unlock()
+		started++
+		return nil
+	}
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	var fields projection
+	fields.add([]string{"data"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			user := types.NamespacedName{Namespace: "default", Name: string(rune('a' + i))}
+			reader, err := informers.reader(context.Background(), user, gvk, fields)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if err := reader.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "db"}, newTestObject("v1", "Secret", "", "")); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if started != 1 {
+		t.Errorf("expected one informer to be started, got %d", started)
+	}
+	if len(informers.pending) != 0 {
+		t.Errorf("expected no pending informers, got %d", len(informers.pending))
+	}
+
+	informers.retain(context.Background(), types.NamespacedName{Namespace: "default", Name: "a"}, nil)
+	if len(informers.informers) != 1 {
+		t.Errorf("expected the informer to be kept while it is used, got %d informers", len(informers.informers))
+	}
+	for i := 1; i < 5; i++ {
+		informers.retain(context.Background(), types.NamespacedName{Namespace: "default", Name: string(rune('a' + i))}, nil)
+	}
+	if len(informers.informers) != 0 {
+		t.Errorf("expected the informer to be stopped once unused, got %d informers", len(informers.informers))
+	}
+}
+
+func TestImpersonatingSourceChangesEnqueue(t *testing.T) {
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+
+	ref := newTestFieldRef("v1", "Secret", "db", "{.data.password}")
+	dynamicResource := newTestDynamicResource("impersonating", newTestObject("v1", "ConfigMap", "", "target"),
+		dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: ref, TargetField: "data.password"})
+	dynamicResource.Spec.ServiceAccountName = "reader"
+
+	r := newTestReconciler(dynamicResource, secret)
+	r.sources = newTestSourceInformers(r, secret)
+
+	requests := make(chan reconcile.Request, 10)
+	r.sources.onStart = func(informer cache.Informer) error {
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(_, obj interface{}) {
+				for _, request := range r.requestsForSource(obj.(client.Object)) {
+					requests <- request
+				}
+			},
+		})
+		return nil
+	}
+
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(dynamicResource)
+	defer r.sources.retain(ctx, key, nil)
+
+	// The value is read through the client of the DynamicResource
+	sources, err := r.resolveSources(ctx, r.Client, dynamicResource, *ref)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Object["data"] == nil {
+		t.Fatalf("expected the source to be read with its data, got %v", sources)
+	}
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	informer, ok := r.sources.informers[gvk]
+	if !ok {
+		t.Fatalf("expected an informer to be started for the source kind")
+	}
+	if !informer.projection.metadataOnly() {
+		t.Errorf("expected the informer to cache metadata only")
+	}
+
+	partial := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", Labels: map[string]string{"changed": "true"}},
+	}
+	metadataClient := r.sources.metadata.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace("default").(metadatafake.MetadataClient)
+	if _, err := metadataClient.UpdateFake(partial, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case request := <-requests:
+		if request.NamespacedName != key {
+			t.Errorf("expected a request for %s, got %s", key, request.NamespacedName)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("expected the source change to enqueue the DynamicResource")
+	}
+}
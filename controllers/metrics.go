@@ -0,0 +1,40 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// activeSourceInformers is the number of source kinds currently served from informer caches
+	activeSourceInformers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dynamicresource_source_informers_active",
+		Help: "Number of active informers serving source objects",
+	})
+
+	// cachedSourceBytes estimates the memory used by the cached objects of each source kind
+	cachedSourceBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dynamicresource_source_cache_bytes",
+		Help: "Estimated size of the cached source objects in bytes",
+	}, []string{"kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(activeSourceInformers, cachedSourceBytes)
+}
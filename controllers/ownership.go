@@ -0,0 +1,124 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// namespaced checks whether the kind is namespace-scoped
+func namespaced(mapper meta.RESTMapper, gvk schema.GroupVersionKind) (bool, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return false, err
+	}
+
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// setOwnership marks the target as controlled by the DynamicResource and records it in the ownership annotation.
+// Owner references are only valid for namespaced targets in the namespace of the DynamicResource,
+// all other targets are tracked by labels and the ownership annotation.
+func setOwnership(mapper meta.RESTMapper, u *unstructured.Unstructured, dynamicResource *dynamickubev1alpha1.DynamicResource, ownerGVK schema.GroupVersionKind) error {
+	isNamespaced, err := namespaced(mapper, u.GroupVersionKind())
+	if err != nil {
+		return errors.WithMessage(err, "Failed to determine scope of target kind")
+	}
+
+	if !isNamespaced {
+		u.SetNamespace("")
+	} else if u.GetNamespace() == "" {
+		u.SetNamespace(dynamicResource.Namespace)
+	}
+
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[dynamickubev1alpha1.OwnerAnnotation] = ownerKey(dynamicResource)
+	u.SetAnnotations(annotations)
+
+	if isNamespaced && u.GetNamespace() == dynamicResource.Namespace {
+		u.SetOwnerReferences(append(u.GetOwnerReferences(), *metav1.NewControllerRef(dynamicResource, ownerGVK)))
+		return nil
+	}
+
+	labels := u.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	labels[dynamickubev1alpha1.OwnerNamespaceLabel] = dynamicResource.Namespace
+	labels[dynamickubev1alpha1.OwnerUIDLabel] = string(dynamicResource.UID)
+	u.SetLabels(labels)
+
+	return nil
+}
+
+// labelOwner returns the DynamicResource controlling obj through the ownership labels, if any.
+// The name of the DynamicResource is read from the ownership annotation, as it may be too long for a label.
+func labelOwner(obj client.Object) (client.ObjectKey, bool) {
+	if obj.GetLabels()[dynamickubev1alpha1.OwnerUIDLabel] == "" {
+		return client.ObjectKey{}, false
+	}
+
+	parts := strings.SplitN(obj.GetAnnotations()[dynamickubev1alpha1.OwnerAnnotation], "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return client.ObjectKey{}, false
+	}
+
+	return client.ObjectKey{Namespace: parts[0], Name: parts[1]}, true
+}
+
+// controlledBy checks whether obj is controlled by the DynamicResource, either by owner reference or by labels
+func controlledBy(obj client.Object, dynamicResource *dynamickubev1alpha1.DynamicResource) bool {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		return owner.UID == dynamicResource.UID
+	}
+
+	return obj.GetLabels()[dynamickubev1alpha1.OwnerUIDLabel] == string(dynamicResource.UID)
+}
+
+// removeOwnership removes the owner reference and ownership labels of the DynamicResource from obj
+func removeOwnership(obj client.Object, dynamicResource *dynamickubev1alpha1.DynamicResource) {
+	refs := obj.GetOwnerReferences()
+	kept := make([]metav1.OwnerReference, 0, len(refs))
+
+	for _, ref := range refs {
+		if ref.UID != dynamicResource.UID {
+			kept = append(kept, ref)
+		}
+	}
+
+	obj.SetOwnerReferences(kept)
+
+	labels := obj.GetLabels()
+	if labels[dynamickubev1alpha1.OwnerUIDLabel] == string(dynamicResource.UID) {
+		delete(labels, dynamickubev1alpha1.OwnerNamespaceLabel)
+		delete(labels, dynamickubev1alpha1.OwnerUIDLabel)
+		obj.SetLabels(labels)
+	}
+}
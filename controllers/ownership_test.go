@@ -0,0 +1,113 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestOwnershipLabels(t *testing.T) {
+	// Names of DynamicResources may be up to 253 characters long, label values only up to 63
+	dynamicResource := newTestDynamicResource(strings.Repeat("a", 253), nil)
+	dynamicResource.UID = "1234"
+
+	u := newTestObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "target")
+	u.SetLabels(map[string]string{"app": "web"})
+
+	if err := setOwnership(newTestRESTMapper(), u, dynamicResource, dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(u.GetOwnerReferences()) != 0 {
+		t.Errorf("expected no owner reference on a cluster-scoped target, got %v", u.GetOwnerReferences())
+	}
+	for key, value := range u.GetLabels() {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			t.Errorf("label %s has an invalid value: %v", key, errs)
+		}
+	}
+
+	key, ok := labelOwner(u)
+	if !ok || key != client.ObjectKeyFromObject(dynamicResource) {
+		t.Errorf("expected owner %s, got %s (%t)", client.ObjectKeyFromObject(dynamicResource), key, ok)
+	}
+	if !controlledBy(u, dynamicResource) {
+		t.Errorf("expected target to be controlled by the DynamicResource")
+	}
+
+	requests := requestsForTarget(u)
+	if len(requests) != 1 || requests[0].NamespacedName != client.ObjectKeyFromObject(dynamicResource) {
+		t.Errorf("expected target to map to the DynamicResource, got %v", requests)
+	}
+
+	removeOwnership(u, dynamicResource)
+	if _, ok := labelOwner(u); ok {
+		t.Errorf("expected no owner after removing ownership")
+	}
+	if labels := u.GetLabels(); len(labels) != 1 || labels["app"] != "web" {
+		t.Errorf("expected only the labels of the target to be kept, got %v", labels)
+	}
+}
+
+func TestLabelOwner(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expected    client.ObjectKey
+		found       bool
+	}{
+		{
+			name:        "owned",
+			labels:      map[string]string{dynamickubev1alpha1.OwnerUIDLabel: "1234"},
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "default/sample"},
+			expected:    client.ObjectKey{Namespace: "default", Name: "sample"},
+			found:       true,
+		},
+		{
+			// Targets controlled by owner reference only carry the annotation
+			name:        "without uid label",
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "default/sample"},
+		},
+		{
+			name:   "without annotation",
+			labels: map[string]string{dynamickubev1alpha1.OwnerUIDLabel: "1234"},
+		},
+		{
+			name:        "malformed annotation",
+			labels:      map[string]string{dynamickubev1alpha1.OwnerUIDLabel: "1234"},
+			annotations: map[string]string{dynamickubev1alpha1.OwnerAnnotation: "sample"},
+		},
+	}
+
+	for _, test := range tests {
+		u := newTestObject("v1", "Secret", "default", "target")
+		u.SetLabels(test.labels)
+		u.SetAnnotations(test.annotations)
+
+		key, found := labelOwner(u)
+		if found != test.found || key != test.expected {
+			t.Errorf("%s: expected %s (%t), got %s (%t)", test.name, test.expected, test.found, key, found)
+		}
+	}
+}
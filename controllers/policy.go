@@ -0,0 +1,134 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// policyViolations evaluates the DynamicResourcePolicies that apply to the namespace of the DynamicResource
+// and describes every source and target reference that is not allowed by them
+func (r *DynamicResourceReconciler) policyViolations(ctx context.Context, dynamicResource *dynamickubev1alpha1.DynamicResource) ([]string, error) {
+	var policies dynamickubev1alpha1.DynamicResourcePolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		return nil, err
+	}
+
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: dynamicResource.Namespace}, &namespace); err != nil {
+		return nil, err
+	}
+
+	var violations []string
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+
+		applies, err := policyApplies(policy, &namespace)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Invalid namespaceSelector of DynamicResourcePolicy %s", policy.Name)
+		}
+
+		if applies {
+			violations = append(violations, r.checkPolicy(dynamicResource, policy)...)
+		}
+	}
+
+	return violations, nil
+}
+
+// policyApplies checks whether the namespace selector of the policy matches the namespace
+func policyApplies(policy *dynamickubev1alpha1.DynamicResourcePolicy, namespace *corev1.Namespace) (bool, error) {
+	if policy.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(namespace.GetLabels())), nil
+}
+
+// checkPolicy describes the source and target references of the DynamicResource that the policy does not allow.
+// References that cannot be evaluated, e.g. of unknown kinds, are left to fail when they are resolved.
+func (r *DynamicResourceReconciler) checkPolicy(dynamicResource *dynamickubev1alpha1.DynamicResource, policy *dynamickubev1alpha1.DynamicResourcePolicy) []string {
+	var violations []string
+
+	for _, ref := range sourceRefs(dynamicResource) {
+		gk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind()
+
+		if !allowedKind(policy.Spec.AllowedSources, gk) {
+			violations = append(violations, fmt.Sprintf("DynamicResourcePolicy %s does not allow reading %s sources", policy.Name, gk))
+		}
+
+		namespace, err := sourceNamespace(r.RESTMapper(), dynamicResource, ref)
+		if err == nil && namespace != "" && namespace != dynamicResource.Namespace && !policy.Spec.AllowCrossNamespace {
+			violations = append(violations, fmt.Sprintf("DynamicResourcePolicy %s does not allow reading sources from namespace %s", policy.Name, namespace))
+		}
+	}
+
+	targets, err := targetObjects(dynamicResource)
+	if err != nil {
+		return violations
+	}
+
+	for _, u := range targets {
+		gk := u.GroupVersionKind().GroupKind()
+
+		if !allowedKind(policy.Spec.AllowedTargets, gk) {
+			violations = append(violations, fmt.Sprintf("DynamicResourcePolicy %s does not allow writing %s targets", policy.Name, gk))
+		}
+
+		isNamespaced, err := namespaced(r.RESTMapper(), u.GroupVersionKind())
+		namespace := u.GetNamespace()
+		if err == nil && isNamespaced && namespace != "" && namespace != dynamicResource.Namespace && !policy.Spec.AllowCrossNamespace {
+			violations = append(violations, fmt.Sprintf("DynamicResourcePolicy %s does not allow writing targets to namespace %s", policy.Name, namespace))
+		}
+	}
+
+	return violations
+}
+
+// allowedKind checks whether the kind matches any of the allowed kinds, an empty list allows all kinds
+func allowedKind(allowed []dynamickubev1alpha1.PolicyKind, gk schema.GroupKind) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, kind := range allowed {
+		if kind.Matches(gk) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestAllowedKind(t *testing.T) {
+	secrets := schema.GroupKind{Kind: "Secret"}
+	deployments := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+
+	tests := []struct {
+		name     string
+		allowed  []dynamickubev1alpha1.PolicyKind
+		gk       schema.GroupKind
+		expected bool
+	}{
+		{"empty list allows all", nil, deployments, true},
+		{"exact match", []dynamickubev1alpha1.PolicyKind{{Kind: "Secret"}}, secrets, true},
+		{"core group does not match other groups", []dynamickubev1alpha1.PolicyKind{{Kind: "Deployment"}}, deployments, false},
+		{"group match", []dynamickubev1alpha1.PolicyKind{{Group: "apps", Kind: "Deployment"}}, deployments, true},
+		{"kind wildcard", []dynamickubev1alpha1.PolicyKind{{Group: "apps", Kind: "*"}}, deployments, true},
+		{"kind wildcard in other group", []dynamickubev1alpha1.PolicyKind{{Group: "apps", Kind: "*"}}, secrets, false},
+		{"group wildcard", []dynamickubev1alpha1.PolicyKind{{Group: "*", Kind: "Secret"}}, secrets, true},
+		{"any of multiple", []dynamickubev1alpha1.PolicyKind{{Kind: "ConfigMap"}, {Kind: "Secret"}}, secrets, true},
+		{"none of multiple", []dynamickubev1alpha1.PolicyKind{{Kind: "ConfigMap"}, {Group: "apps", Kind: "Secret"}}, secrets, false},
+	}
+
+	for _, test := range tests {
+		if allowed := allowedKind(test.allowed, test.gk); allowed != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, allowed)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	// readSecret returns a DynamicResource reading a Secret from sourceNamespace and writing the target
+	readSecret := func(sourceNamespace string, target *unstructured.Unstructured) *dynamickubev1alpha1.DynamicResource {
+		ref := newTestFieldRef("v1", "Secret", "source", ".data.a")
+		ref.Namespace = sourceNamespace
+		return newTestDynamicResource("sample", target, dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: ref, TargetField: "data.a"})
+	}
+	configMap := func(namespace string) *unstructured.Unstructured {
+		return newTestObject("v1", "ConfigMap", namespace, "target")
+	}
+	clusterRole := newTestObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "target")
+
+	tests := []struct {
+		name            string
+		spec            dynamickubev1alpha1.DynamicResourcePolicySpec
+		dynamicResource *dynamickubev1alpha1.DynamicResource
+		expected        []string
+	}{
+		{
+			name:            "empty policy allows same namespace",
+			dynamicResource: readSecret("", configMap("default")),
+		},
+		{
+			name: "allowed kinds",
+			spec: dynamickubev1alpha1.DynamicResourcePolicySpec{
+				AllowedSources: []dynamickubev1alpha1.PolicyKind{{Kind: "Secret"}},
+				AllowedTargets: []dynamickubev1alpha1.PolicyKind{{Kind: "ConfigMap"}},
+			},
+			dynamicResource: readSecret("", configMap("")),
+		},
+		{
+			name: "disallowed kinds",
+			spec: dynamickubev1alpha1.DynamicResourcePolicySpec{
+				AllowedSources: []dynamickubev1alpha1.PolicyKind{{Kind: "ConfigMap"}},
+				AllowedTargets: []dynamickubev1alpha1.PolicyKind{{Kind: "Secret"}},
+			},
+			dynamicResource: readSecret("", configMap("default")),
+			expected: []string{
+				"DynamicResourcePolicy restrict does not allow reading Secret sources",
+				"DynamicResourcePolicy restrict does not allow writing ConfigMap targets",
+			},
+		},
+		{
+			name:            "cross-namespace source and target",
+			dynamicResource: readSecret("shared", configMap("other")),
+			expected: []string{
+				"DynamicResourcePolicy restrict does not allow reading sources from namespace shared",
+				"DynamicResourcePolicy restrict does not allow writing targets to namespace other",
+			},
+		},
+		{
+			name:            "cross-namespace allowed",
+			spec:            dynamickubev1alpha1.DynamicResourcePolicySpec{AllowCrossNamespace: true},
+			dynamicResource: readSecret("shared", configMap("other")),
+		},
+		{
+			name:            "cluster-scoped targets are not cross-namespace",
+			dynamicResource: readSecret("", clusterRole),
+		},
+	}
+
+	r := newTestReconciler()
+	for _, test := range tests {
+		policy := &dynamickubev1alpha1.DynamicResourcePolicy{ObjectMeta: metav1.ObjectMeta{Name: "restrict"}, Spec: test.spec}
+
+		violations := r.checkPolicy(test.dynamicResource, policy)
+		if !reflect.DeepEqual(violations, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, violations)
+		}
+	}
+}
+
+func TestPolicyViolations(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"restricted": "true"}}}
+	restricted := &dynamickubev1alpha1.DynamicResourcePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "restricted"},
+		Spec: dynamickubev1alpha1.DynamicResourcePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"restricted": "true"}},
+			AllowedSources:    []dynamickubev1alpha1.PolicyKind{{Kind: "ConfigMap"}},
+		},
+	}
+	unrelated := &dynamickubev1alpha1.DynamicResourcePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated"},
+		Spec: dynamickubev1alpha1.DynamicResourcePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"restricted": "false"}},
+			AllowedTargets:    []dynamickubev1alpha1.PolicyKind{{Kind: "Secret"}},
+		},
+	}
+
+	dynamicResource := newTestDynamicResource("sample", newTestObject("v1", "ConfigMap", "", "target"),
+		dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: newTestFieldRef("v1", "Secret", "source", ".data.a"), TargetField: "data.a"})
+
+	r := newTestReconciler(namespace, restricted, unrelated)
+	violations, err := r.policyViolations(context.Background(), dynamicResource)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"DynamicResourcePolicy restricted does not allow reading Secret sources"}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("expected %q, got %q", expected, violations)
+	}
+}
+
+func TestRequestsForNamespace(t *testing.T) {
+	inNamespace := newTestDynamicResource("sample", nil)
+	elsewhere := newTestDynamicResource("sample", nil)
+	elsewhere.Namespace = "other"
+
+	r := newTestReconciler(inNamespace, elsewhere)
+	requests := r.requestsForNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+
+	expected := []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(inNamespace)}}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected %v, got %v", expected, requests)
+	}
+}
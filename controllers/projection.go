@@ -0,0 +1,281 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
+)
+
+// fieldTree is a tree of field names. A leaf selects the whole value of the field.
+type fieldTree map[string]fieldTree
+
+// projection describes the fields of source objects that are kept in the cache.
+// The apiVersion, kind and metadata (except managedFields) of objects are always kept.
+type projection struct {
+	full   bool
+	fields fieldTree
+}
+
+// fullProjection keeps source objects as they are
+var fullProjection = projection{full: true}
+
+// add adds the field at path to the projection, an empty path selects the whole object
+func (p *projection) add(path []string) {
+	if p.full {
+		return
+	}
+	if len(path) == 0 {
+		*p = fullProjection
+		return
+	}
+	if p.fields == nil {
+		p.fields = fieldTree{}
+	}
+
+	tree := p.fields
+	for i, name := range path {
+		sub, ok := tree[name]
+		if ok && len(sub) == 0 {
+			// The whole value is selected already
+			return
+		}
+
+		if i == len(path)-1 {
+			tree[name] = fieldTree{}
+			return
+		}
+
+		if !ok {
+			sub = fieldTree{}
+			tree[name] = sub
+		}
+		tree = sub
+	}
+}
+
+// merge adds all fields of other to the projection
+func (p *projection) merge(other projection) {
+	if other.full {
+		*p = fullProjection
+		return
+	}
+
+	var walk func(tree fieldTree, prefix []string)
+	walk = func(tree fieldTree, prefix []string) {
+		for name, sub := range tree {
+			path := append(append([]string{}, prefix...), name)
+			if len(sub) == 0 {
+				p.add(path)
+			} else {
+				walk(sub, path)
+			}
+		}
+	}
+	walk(other.fields, nil)
+}
+
+// covers checks whether all fields of other are kept by the projection
+func (p projection) covers(other projection) bool {
+	if p.full {
+		return true
+	}
+	if other.full {
+		return false
+	}
+
+	var covers func(tree, other fieldTree) bool
+	covers = func(tree, other fieldTree) bool {
+		for name, otherSub := range other {
+			sub, ok := tree[name]
+			if !ok {
+				return false
+			}
+			if len(sub) == 0 {
+				continue
+			}
+			if len(otherSub) == 0 || !covers(sub, otherSub) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return covers(p.fields, other.fields)
+}
+
+// metadataOnly checks whether the projection only references fields of the object metadata
+func (p projection) metadataOnly() bool {
+	if p.full {
+		return false
+	}
+
+	for name := range p.fields {
+		if name != "metadata" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply returns a copy of obj that only contains the fields of the projection.
+// Values are not copied, so obj must not be used afterwards.
+func (p projection) apply(obj map[string]interface{}) map[string]interface{} {
+	if p.full {
+		return obj
+	}
+
+	projected := map[string]interface{}{
+		"apiVersion": obj["apiVersion"],
+		"kind":       obj["kind"],
+	}
+
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		kept := make(map[string]interface{}, len(metadata))
+		for name, value := range metadata {
+			if name != "managedFields" {
+				kept[name] = value
+			}
+		}
+		projected["metadata"] = kept
+	}
+
+	copyFields(projected, obj, p.fields)
+
+	return projected
+}
+
+// copyFields copies the fields of tree from src to dst
+func copyFields(dst, src map[string]interface{}, tree fieldTree) {
+	for name, sub := range tree {
+		value, ok := src[name]
+		if !ok {
+			continue
+		}
+
+		child, isMap := value.(map[string]interface{})
+		if len(sub) == 0 || !isMap {
+			dst[name] = value
+			continue
+		}
+
+		dstChild, ok := dst[name].(map[string]interface{})
+		if !ok {
+			dstChild = map[string]interface{}{}
+			dst[name] = dstChild
+		}
+		copyFields(dstChild, child, sub)
+	}
+}
+
+// fieldSpecPaths returns the paths of the fields a FieldSpec reads. Each path ends before the first
+// element that is not a plain field, e.g. an array index or filter, and selects the whole value there.
+func fieldSpecPaths(fieldSpec string) ([][]string, error) {
+	expression, err := transform.NormalizeFieldSpec(fieldSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	parser, err := jsonpath.Parse("", expression)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths [][]string
+	for _, node := range parser.Root.Nodes {
+		list, ok := node.(*jsonpath.ListNode)
+		if !ok {
+			continue
+		}
+
+		var path []string
+		for _, node := range list.Nodes {
+			field, ok := node.(*jsonpath.FieldNode)
+			if !ok {
+				break
+			}
+
+			// The root object ($) is a field without name
+			if field.Value != "" {
+				path = append(path, field.Value)
+			}
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// sourceProjection returns the fields of sources of the given kind that are read by a DynamicResource.
+// Unparsable FieldSpecs select the whole object, they are reported when the transformation is resolved.
+func sourceProjection(dynamicResource *dynamickubev1alpha1.DynamicResource, gvk schema.GroupVersionKind) projection {
+	var p projection
+
+	for _, ref := range sourceRefs(dynamicResource) {
+		if schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind) != gvk {
+			continue
+		}
+
+		paths, err := fieldSpecPaths(ref.FieldSpec)
+		if err != nil {
+			return fullProjection
+		}
+		for _, path := range paths {
+			p.add(path)
+		}
+
+		// Field selectors are evaluated on cached objects
+		if ref.FieldSelector != "" {
+			selector, err := fields.ParseSelector(ref.FieldSelector)
+			if err != nil {
+				return fullProjection
+			}
+			for _, requirement := range selector.Requirements() {
+				p.add(strings.Split(requirement.Field, "."))
+			}
+		}
+	}
+
+	return p
+}
+
+// requiredProjection returns the fields of sources of the given kind that are read by any DynamicResource in the cache
+func (r *DynamicResourceReconciler) requiredProjection(ctx context.Context, gvk schema.GroupVersionKind) (projection, error) {
+	var dynamicResources dynamickubev1alpha1.DynamicResourceList
+	if err := r.List(ctx, &dynamicResources); err != nil {
+		return projection{}, err
+	}
+
+	var p projection
+	for i := range dynamicResources.Items {
+		// Sources of impersonating DynamicResources are not read from informers, they are only watched
+		if dynamicResources.Items[i].Spec.ServiceAccountName == "" {
+			p.merge(sourceProjection(&dynamicResources.Items[i], gvk))
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// newProjection builds a projection of the given paths
+func newProjection(paths ...[]string) projection {
+	var p projection
+	for _, path := range paths {
+		p.add(path)
+	}
+	return p
+}
+
+func TestProjectionAdd(t *testing.T) {
+	p := newProjection([]string{"data", "a"}, []string{"data", "b"}, []string{"spec"})
+	expected := fieldTree{"data": {"a": {}, "b": {}}, "spec": {}}
+	if !reflect.DeepEqual(p.fields, expected) {
+		t.Errorf("expected %v, got %v", expected, p.fields)
+	}
+
+	// Fields below a selected field are covered already
+	p.add([]string{"spec", "replicas"})
+	if !reflect.DeepEqual(p.fields, expected) {
+		t.Errorf("expected %v, got %v", expected, p.fields)
+	}
+
+	// Selecting a parent replaces its children
+	p.add([]string{"data"})
+	if !reflect.DeepEqual(p.fields, fieldTree{"data": {}, "spec": {}}) {
+		t.Errorf("expected data to be selected as a whole, got %v", p.fields)
+	}
+
+	// An empty path selects the whole object
+	p.add(nil)
+	if !p.full {
+		t.Errorf("expected a full projection")
+	}
+	p.add([]string{"data"})
+	if !p.full || p.fields != nil {
+		t.Errorf("expected the projection to stay full")
+	}
+}
+
+func TestProjectionMergeAndCovers(t *testing.T) {
+	data := newProjection([]string{"data", "a"})
+	spec := newProjection([]string{"spec", "replicas"})
+
+	var merged projection
+	merged.merge(data)
+	merged.merge(spec)
+
+	tests := []struct {
+		name     string
+		p        projection
+		other    projection
+		expected bool
+	}{
+		{"same fields", data, data, true},
+		{"merged covers both", merged, data, true},
+		{"merged covers other", merged, spec, true},
+		{"missing field", data, spec, false},
+		{"parent covers child", newProjection([]string{"data"}), data, true},
+		{"child does not cover parent", data, newProjection([]string{"data"}), false},
+		{"sibling", data, newProjection([]string{"data", "b"}), false},
+		{"full covers all", fullProjection, merged, true},
+		{"nothing covers full", merged, fullProjection, false},
+		{"empty is covered", data, projection{}, true},
+	}
+
+	for _, test := range tests {
+		if covers := test.p.covers(test.other); covers != test.expected {
+			t.Errorf("%s: expected covers to be %v", test.name, test.expected)
+		}
+	}
+
+	full := newProjection([]string{"data", "a"})
+	full.merge(fullProjection)
+	if !full.full {
+		t.Errorf("expected merging a full projection to result in a full projection")
+	}
+}
+
+func TestProjectionMetadataOnly(t *testing.T) {
+	if !newProjection([]string{"metadata", "labels"}).metadataOnly() {
+		t.Errorf("expected metadata fields to be metadata only")
+	}
+	if newProjection([]string{"metadata", "labels"}, []string{"data"}).metadataOnly() {
+		t.Errorf("expected data fields not to be metadata only")
+	}
+	if fullProjection.metadataOnly() {
+		t.Errorf("expected the full projection not to be metadata only")
+	}
+}
+
+func TestProjectionApply(t *testing.T) {
+	obj := func() map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":          "source",
+				"managedFields": []interface{}{map[string]interface{}{"manager": "kubectl"}},
+			},
+			"data": map[string]interface{}{"a": "1", "b": "2"},
+			"type": "Opaque",
+		}
+	}
+
+	projected := newProjection([]string{"data", "a"}, []string{"type", "missing"}, []string{"absent"}).apply(obj())
+	expected := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "source"},
+		"data":       map[string]interface{}{"a": "1"},
+		"type":       "Opaque",
+	}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("expected %v, got %v", expected, projected)
+	}
+
+	if full := fullProjection.apply(obj()); !reflect.DeepEqual(full, obj()) {
+		t.Errorf("expected the full projection to keep the object, got %v", full)
+	}
+}
+
+func TestFieldSpecPaths(t *testing.T) {
+	tests := []struct {
+		fieldSpec string
+		expected  [][]string
+	}{
+		{"data.password", [][]string{{"data", "password"}}},
+		{"{.data.password}", [][]string{{"data", "password"}}},
+		{"{$.spec.replicas}", [][]string{{"spec", "replicas"}}},
+		{"{.spec.containers[0].image}", [][]string{{"spec", "containers"}}},
+		{`{.status.conditions[?(@.type=="Ready")].status}`, [][]string{{"status", "conditions"}}},
+		{"{.items[*].name}", [][]string{{"items"}}},
+	}
+
+	for _, test := range tests {
+		paths, err := fieldSpecPaths(test.fieldSpec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.fieldSpec, err)
+			continue
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.fieldSpec, test.expected, paths)
+		}
+	}
+
+	if _, err := fieldSpecPaths("{.data"); err == nil {
+		t.Errorf("expected unparsable FieldSpec to fail")
+	}
+}
+
+// readSecretField returns a transformation reading the field of the Secret named source
+func readSecretField(fieldSpec string) dynamickubev1alpha1.DynamicResourceTransformation {
+	return dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: newTestFieldRef("v1", "Secret", "source", fieldSpec), TargetField: "data.value"}
+}
+
+func TestSourceProjection(t *testing.T) {
+	secrets := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+
+	dynamicResource := newTestDynamicResource("sample", nil, readSecretField(".data.a"), readSecretField(".metadata.labels"))
+	dynamicResource.Spec.Transformations[0].FieldFrom.Name = ""
+	dynamicResource.Spec.Transformations[0].FieldFrom.FieldSelector = "type=Opaque"
+
+	expected := newProjection([]string{"data", "a"}, []string{"metadata", "labels"}, []string{"type"})
+	if p := sourceProjection(dynamicResource, secrets); !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected %v, got %v", expected.fields, p.fields)
+	}
+
+	if p := sourceProjection(dynamicResource, schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}); !reflect.DeepEqual(p, projection{}) {
+		t.Errorf("expected no fields of other kinds, got %v", p.fields)
+	}
+
+	if p := sourceProjection(newTestDynamicResource("invalid", nil, readSecretField("{.data")), secrets); !p.full {
+		t.Errorf("expected unparsable FieldSpecs to select the whole object")
+	}
+}
+
+func TestRequiredProjection(t *testing.T) {
+	impersonating := newTestDynamicResource("impersonating", nil, readSecretField(".data.c"))
+	impersonating.Spec.ServiceAccountName = "reader"
+
+	r := newTestReconciler(
+		newTestDynamicResource("first", nil, readSecretField(".data.a")),
+		newTestDynamicResource("second", nil, readSecretField(".data.b")),
+		impersonating,
+	)
+
+	p, err := r.requiredProjection(context.Background(), schema.GroupVersionKind{Version: "v1", Kind: "Secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The fields of all DynamicResources are kept, before any of them was reconciled
+	expected := newProjection([]string{"data", "a"}, []string{"data", "b"})
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected %v, got %v", expected.fields, p.fields)
+	}
+}
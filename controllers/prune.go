@@ -0,0 +1,133 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// targetReference builds the status reference of a target object
+func targetReference(u *unstructured.Unstructured) dynamickubev1alpha1.TargetReference {
+	return dynamickubev1alpha1.TargetReference{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Namespace:  u.GetNamespace(),
+		Name:       u.GetName(),
+		UID:        u.GetUID(),
+	}
+}
+
+// sameTarget checks whether two references identify the same object, regardless of the API version
+func sameTarget(a, b dynamickubev1alpha1.TargetReference) bool {
+	return schema.FromAPIVersionAndKind(a.APIVersion, a.Kind).GroupKind() == schema.FromAPIVersionAndKind(b.APIVersion, b.Kind).GroupKind() &&
+		a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+// findTargetRef looks up the reference identifying the same object as ref
+func findTargetRef(refs []dynamickubev1alpha1.TargetReference, ref dynamickubev1alpha1.TargetReference) (dynamickubev1alpha1.TargetReference, bool) {
+	for _, r := range refs {
+		if sameTarget(r, ref) {
+			return r, true
+		}
+	}
+
+	return dynamickubev1alpha1.TargetReference{}, false
+}
+
+// pruneTargets deletes the previously applied targets that are no longer rendered. The references of
+// targets that failed to be deleted are returned, so that deleting them is retried.
+// If pruning is disabled, the references of all targets that are no longer rendered are returned, so that
+// the deletion policy is still applied to them when the DynamicResource is deleted.
+func (r *DynamicResourceReconciler) pruneTargets(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, previous []dynamickubev1alpha1.TargetReference, targets []*unstructured.Unstructured) ([]dynamickubev1alpha1.TargetReference, error) {
+	prune := dynamicResource.Spec.Prune == nil || *dynamicResource.Spec.Prune
+
+	var retained []dynamickubev1alpha1.TargetReference
+	var errs []error
+
+	for _, ref := range previous {
+		rendered := false
+		for _, u := range targets {
+			if sameTarget(ref, targetReference(u)) {
+				rendered = true
+				break
+			}
+		}
+		if rendered {
+			continue
+		}
+
+		if !prune {
+			retained = append(retained, ref)
+			continue
+		}
+
+		if err := r.pruneTarget(ctx, c, dynamicResource, ref); err != nil {
+			errs = append(errs, errors.WithMessagef(err, "Failed to prune %s %s/%s", ref.Kind, ref.Namespace, ref.Name))
+			retained = append(retained, ref)
+		}
+	}
+
+	return retained, utilerrors.NewAggregate(errs)
+}
+
+// pruneTarget deletes a single target, unless it is no longer controlled by the DynamicResource
+// or pruning is disabled for it
+func (r *DynamicResourceReconciler) pruneTarget(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, ref dynamickubev1alpha1.TargetReference) error {
+	logger := log.FromContext(ctx)
+
+	live := &unstructured.Unstructured{}
+	live.SetAPIVersion(ref.APIVersion)
+	live.SetKind(ref.Kind)
+
+	err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, live)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if live.GetAnnotations()[dynamickubev1alpha1.PruneAnnotation] == dynamickubev1alpha1.PruneDisabled {
+		logger.Info("Pruning of target is disabled", "resource", client.ObjectKeyFromObject(live))
+		return nil
+	}
+
+	if !controlledBy(live, dynamicResource) {
+		return nil
+	}
+
+	uid := live.GetUID()
+	err = c.Delete(ctx, live, client.Preconditions{UID: &uid})
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	logger.Info("Pruned target", "resource", client.ObjectKeyFromObject(live))
+	r.recorder(ctx).Eventf(dynamicResource, corev1.EventTypeNormal, "TargetPruned", "Deleted %s %s, as it is no longer rendered", ref.Kind, client.ObjectKeyFromObject(live))
+
+	return nil
+}
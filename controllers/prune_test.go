@@ -0,0 +1,154 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// failingDeleteClient fails to delete any object
+type failingDeleteClient struct {
+	client.Client
+}
+
+func (c failingDeleteClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return errors.New("delete failed")
+}
+
+func TestSameTarget(t *testing.T) {
+	ref := dynamickubev1alpha1.TargetReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app"}
+
+	tests := []struct {
+		name     string
+		other    dynamickubev1alpha1.TargetReference
+		expected bool
+	}{
+		{"same object", ref, true},
+		{"other version", dynamickubev1alpha1.TargetReference{APIVersion: "apps/v1beta1", Kind: "Deployment", Namespace: "default", Name: "app"}, true},
+		{"other group", dynamickubev1alpha1.TargetReference{APIVersion: "example.com/v1", Kind: "Deployment", Namespace: "default", Name: "app"}, false},
+		{"other kind", dynamickubev1alpha1.TargetReference{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "default", Name: "app"}, false},
+		{"other namespace", dynamickubev1alpha1.TargetReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "other", Name: "app"}, false},
+		{"other name", dynamickubev1alpha1.TargetReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "web"}, false},
+	}
+
+	for _, test := range tests {
+		if same := sameTarget(ref, test.other); same != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, same)
+		}
+	}
+}
+
+func TestPruneTargets(t *testing.T) {
+	dynamicResource := newTestDynamicResource("sample", nil)
+	controllerRef := *metav1.NewControllerRef(dynamicResource, dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource"))
+	foreignRef := *metav1.NewControllerRef(newTestDynamicResource("other", nil), dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource"))
+
+	secret := func(name string, owner *metav1.OwnerReference, annotations map[string]string) *corev1.Secret {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations}}
+		if owner != nil {
+			s.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return s
+	}
+	ref := func(name string) dynamickubev1alpha1.TargetReference {
+		return dynamickubev1alpha1.TargetReference{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: name}
+	}
+	objects := func() []client.Object {
+		return []client.Object{
+			secret("rendered", &controllerRef, nil),
+			secret("stale", &controllerRef, nil),
+			secret("disabled", &controllerRef, map[string]string{dynamickubev1alpha1.PruneAnnotation: dynamickubev1alpha1.PruneDisabled}),
+			secret("foreign", &foreignRef, nil),
+			secret("unowned", nil, nil),
+		}
+	}
+	previous := []dynamickubev1alpha1.TargetReference{ref("rendered"), ref("stale"), ref("disabled"), ref("foreign"), ref("unowned"), ref("gone")}
+	targets := []*unstructured.Unstructured{newTestObject("v1", "Secret", "default", "rendered")}
+
+	disabled := false
+	enabled := true
+
+	tests := []struct {
+		name     string
+		prune    *bool
+		deleted  []string
+		retained []string
+		fail     bool
+	}{
+		{name: "prune by default", deleted: []string{"stale"}},
+		{name: "prune enabled", prune: &enabled, deleted: []string{"stale"}},
+		{name: "prune disabled", prune: &disabled, retained: []string{"stale", "disabled", "foreign", "unowned", "gone"}},
+		{name: "failed deletion is retried", retained: []string{"stale"}, fail: true},
+	}
+
+	for _, test := range tests {
+		r := newTestReconciler(objects()...)
+		if test.fail {
+			r.Client = failingDeleteClient{r.Client}
+		}
+		dynamicResource.Spec.Prune = test.prune
+
+		retained, err := r.pruneTargets(context.Background(), r.Client, dynamicResource, previous, targets)
+		if test.fail != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if len(retained) != len(test.retained) {
+			t.Errorf("%s: expected %d retained targets, got %v", test.name, len(test.retained), retained)
+		}
+		for _, name := range test.retained {
+			if _, ok := findTargetRef(retained, ref(name)); !ok {
+				t.Errorf("%s: expected %s to be retained", test.name, name)
+			}
+		}
+
+		for _, obj := range objects() {
+			err := r.Get(context.Background(), client.ObjectKeyFromObject(obj), &corev1.Secret{})
+			wantDeleted := false
+			for _, name := range test.deleted {
+				wantDeleted = wantDeleted || name == obj.GetName()
+			}
+			if wantDeleted && !apierrors.IsNotFound(err) {
+				t.Errorf("%s: expected %s to be pruned, got %v", test.name, obj.GetName(), err)
+			}
+			if !wantDeleted && err != nil {
+				t.Errorf("%s: expected %s to be kept, got %v", test.name, obj.GetName(), err)
+			}
+		}
+
+		events := r.Recorder.(*record.FakeRecorder).Events
+		if len(events) != len(test.deleted) {
+			t.Errorf("%s: expected %d events, got %d", test.name, len(test.deleted), len(events))
+		}
+		for range test.deleted {
+			if event := <-events; event != "Normal TargetPruned Deleted Secret default/stale, as it is no longer rendered" {
+				t.Errorf("%s: unexpected event %q", test.name, event)
+			}
+		}
+	}
+}
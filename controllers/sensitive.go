@@ -0,0 +1,85 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/jsonpath"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/sensitive"
+)
+
+// isSensitive checks whether the values read by ref need to be redacted
+func isSensitive(ref dynamickubev1alpha1.ExternalFieldRef) bool {
+	return ref.Sensitive || schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind) == secretGVK
+}
+
+// transformationSensitive checks whether any source of the transformation is sensitive,
+// in which case the value it renders is sensitive as well
+func transformationSensitive(trans dynamickubev1alpha1.DynamicResourceTransformation) bool {
+	if trans.FieldFrom != nil && isSensitive(*trans.FieldFrom) {
+		return true
+	}
+
+	for _, ref := range trans.Sources {
+		if isSensitive(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trackResults marks all values the FieldSpec yields for src as sensitive. This happens before the values
+// are extracted and converted, so that they are already redacted from the errors of a failed conversion.
+func trackResults(tracker *sensitive.Tracker, j *jsonpath.JSONPath, src *unstructured.Unstructured) {
+	results, err := j.FindResults(src.Object)
+	if err != nil {
+		return
+	}
+
+	for _, result := range results {
+		for _, value := range result {
+			if value.IsValid() && value.CanInterface() {
+				tracker.Track(value.Interface())
+			}
+		}
+	}
+}
+
+// redactStatus replaces the sensitive values in all messages of the status by their hash
+func redactStatus(tracker *sensitive.Tracker, status *dynamickubev1alpha1.DynamicResourceStatus) {
+	status.LastError = tracker.Redact(status.LastError)
+
+	for i := range status.Conditions {
+		status.Conditions[i].Message = tracker.Redact(status.Conditions[i].Message)
+	}
+
+	for i := range status.Transformations {
+		status.Transformations[i].Error = tracker.Redact(status.Transformations[i].Error)
+	}
+}
+
+// recorder returns an event recorder that redacts the sensitive values read during the current reconciliation
+func (r *DynamicResourceReconciler) recorder(ctx context.Context) record.EventRecorder {
+	return sensitive.Recorder(r.Recorder, sensitive.FromContext(ctx))
+}
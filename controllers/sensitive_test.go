@@ -0,0 +1,181 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/sensitive"
+)
+
+// newTestSourceInformers returns source informers that list and watch the objects through fake dynamic and metadata clients
+func newTestSourceInformers(r *DynamicResourceReconciler, objs ...runtime.Object) *sourceInformers {
+	metadataScheme := runtime.NewScheme()
+	_ = metav1.AddMetaToScheme(metadataScheme)
+	metadataClient := metadatafake.NewSimpleMetadataClient(metadataScheme)
+
+	for _, obj := range objs {
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		mapping, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			panic(err)
+		}
+
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			panic(err)
+		}
+
+		partial := &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+			ObjectMeta: metav1.ObjectMeta{Namespace: accessor.GetNamespace(), Name: accessor.GetName(), Labels: accessor.GetLabels()},
+		}
+		fakeClient := metadataClient.Resource(mapping.Resource).Namespace(accessor.GetNamespace()).(metadatafake.MetadataClient)
+		if _, err := fakeClient.CreateFake(partial, metav1.CreateOptions{}); err != nil {
+			panic(err)
+		}
+	}
+
+	return &sourceInformers{
+		dynamic:   dynamicfake.NewSimpleDynamicClient(r.Scheme, objs...),
+		metadata:  metadataClient,
+		mapper:    r.RESTMapper(),
+		onStart:   func(cache.Informer) error { return nil },
+		required:  r.requiredProjection,
+		informers: map[schema.GroupVersionKind]*sourceInformer{},
+		users:     map[types.NamespacedName]map[schema.GroupVersionKind]projection{},
+		pending:   map[schema.GroupVersionKind]chan struct{}{},
+	}
+}
+
+func TestReconcileRedactsSensitiveValues(t *testing.T) {
+	const password = "s3cr3t-p@ss"
+
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte(password)},
+	}
+
+	// The password cannot be converted to an Int, the conversion error quotes it
+	dynamicResource := &dynamickubev1alpha1.DynamicResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+		Spec: dynamickubev1alpha1.DynamicResourceSpec{
+			Target: &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "target"},
+			}},
+			Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+				{
+					FieldFrom: &dynamickubev1alpha1.ExternalFieldRef{
+						TypeMeta:  metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+						Name:      "db",
+						FieldSpec: "{.data.password}",
+					},
+					TargetField: "data.port",
+					ValueType:   dynamickubev1alpha1.ValueTypeInt,
+				},
+			},
+		},
+	}
+
+	r := newTestReconciler(dynamicResource)
+	r.sources = newTestSourceInformers(r, secret)
+	r.programs = newCELPrograms()
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	var logs []string
+	ctx := log.IntoContext(context.Background(), funcr.New(func(prefix, args string) {
+		logs = append(logs, prefix+" "+args)
+	}, funcr.Options{Verbosity: 1}))
+
+	key := client.ObjectKeyFromObject(dynamicResource)
+	defer r.sources.retain(ctx, key, nil)
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: key}); err == nil {
+		t.Fatalf("expected reconciliation to fail")
+	} else {
+		assertNoPlaintext(t, "returned error", err.Error(), password)
+	}
+
+	var reconciled dynamickubev1alpha1.DynamicResource
+	if err := r.Get(ctx, key, &reconciled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status := reconciled.Status
+	if !strings.Contains(status.LastError, sensitive.Hash(password)) {
+		t.Errorf("expected lastError to contain the hash of the value, got %q", status.LastError)
+	}
+	assertNoPlaintext(t, "lastError", status.LastError, password)
+
+	if len(status.Transformations) == 0 || status.Transformations[0].Error == "" {
+		t.Errorf("expected the error of the transformation to be reported, got %+v", status.Transformations)
+	}
+	for _, trans := range status.Transformations {
+		assertNoPlaintext(t, "transformation error", trans.Error, password)
+	}
+
+	if len(status.Conditions) == 0 {
+		t.Errorf("expected conditions to be reported")
+	}
+	for _, condition := range status.Conditions {
+		assertNoPlaintext(t, "condition "+condition.Type, condition.Message, password)
+	}
+
+	close(recorder.Events)
+	for event := range recorder.Events {
+		assertNoPlaintext(t, "event", event, password)
+	}
+
+	for _, line := range logs {
+		assertNoPlaintext(t, "log entry", line, password)
+	}
+}
+
+// assertNoPlaintext fails if message contains the value or its base64 encoded form
+func assertNoPlaintext(t *testing.T, what, message, value string) {
+	t.Helper()
+
+	for _, plaintext := range []string{value, base64.StdEncoding.EncodeToString([]byte(value))} {
+		if strings.Contains(message, plaintext) {
+			t.Errorf("%s %q contains plaintext %q", what, message, plaintext)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// sourceRefs returns all source references of the transformations of a DynamicResource
+func sourceRefs(dynamicResource *dynamickubev1alpha1.DynamicResource) []dynamickubev1alpha1.ExternalFieldRef {
+	var refs []dynamickubev1alpha1.ExternalFieldRef
+
+	for _, trans := range dynamicResource.Spec.Transformations {
+		if trans.FieldFrom != nil {
+			refs = append(refs, *trans.FieldFrom)
+		}
+		for _, name := range sortedSourceNames(trans.Sources) {
+			refs = append(refs, trans.Sources[name])
+		}
+	}
+
+	return refs
+}
+
+// sortedSourceNames returns the names of named sources in a stable order
+func sortedSourceNames(sources map[string]dynamickubev1alpha1.ExternalFieldRef) []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// validateSourceRef checks that a source is either referenced by name or by selectors
+func validateSourceRef(ref dynamickubev1alpha1.ExternalFieldRef) error {
+	hasSelector := ref.Selector != nil || ref.FieldSelector != ""
+
+	if ref.Name != "" && hasSelector {
+		return errors.New("name is mutually exclusive with selector and fieldSelector")
+	} else if ref.Name == "" && !hasSelector {
+		return errors.New("one of name, selector or fieldSelector is required")
+	} else if hasSelector && ref.Pick == "" {
+		return errors.New("pick is required when using selector or fieldSelector")
+	}
+
+	return nil
+}
+
+// sourceNamespace returns the namespace to read the source from. Cluster-scoped
+// sources are read without a namespace, namespaced sources default to the namespace
+// of the DynamicResource.
+func sourceNamespace(mapper meta.RESTMapper, dynamicResource *dynamickubev1alpha1.DynamicResource, ref dynamickubev1alpha1.ExternalFieldRef) (string, error) {
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+
+	isNamespaced, err := namespaced(mapper, gvk)
+	if err != nil {
+		return "", errors.WithMessage(err, "Failed to determine scope of fieldFrom source kind")
+	}
+
+	if !isNamespaced {
+		return "", nil
+	}
+
+	if ref.Namespace != "" {
+		return ref.Namespace, nil
+	}
+
+	return dynamicResource.Namespace, nil
+}
+
+// resolveSources retrieves the source objects referenced by ref. Sources referenced by name
+// always yield a single object, matched sources are picked according to the pick policy.
+func (r *DynamicResourceReconciler) resolveSources(ctx context.Context, c client.Client, dynamicResource *dynamickubev1alpha1.DynamicResource, ref dynamickubev1alpha1.ExternalFieldRef) ([]*unstructured.Unstructured, error) {
+	if err := validateSourceRef(ref); err != nil {
+		return nil, err
+	}
+
+	namespace, err := sourceNamespace(r.RESTMapper(), dynamicResource, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace != "" && namespace != dynamicResource.Namespace && !r.AllowCrossNamespaceSources {
+		return nil, errors.New(fmt.Sprintf("Reading sources from namespace '%s' is not allowed", namespace))
+	}
+
+	// Sources are read from the informer of their kind, which also notifies about their changes.
+	// The shared informers are not authorized for the impersonated ServiceAccount, so impersonating
+	// DynamicResources read their sources from the API server and only use the informer, that caches
+	// no more than the metadata for them, to be notified.
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+	var reader client.Reader = c
+	if dynamicResource.Spec.ServiceAccountName == "" {
+		reader, err = r.sources.reader(ctx, client.ObjectKeyFromObject(dynamicResource), gvk, sourceProjection(dynamicResource, gvk))
+		if err != nil {
+			return nil, errors.WithMessage(err, "Failed to read fieldFrom source kind")
+		}
+	} else if _, err := r.sources.reader(ctx, client.ObjectKeyFromObject(dynamicResource), gvk, projection{}); err != nil {
+		return nil, errors.WithMessage(err, "Failed to watch fieldFrom source kind")
+	}
+
+	if ref.Name != "" {
+		src := &unstructured.Unstructured{}
+		src.SetGroupVersionKind(gvk)
+
+		key := client.ObjectKey{Namespace: namespace, Name: ref.Name}
+		if err := reader.Get(ctx, key, src); err != nil {
+			return nil, err
+		}
+
+		return []*unstructured.Unstructured{src}, nil
+	}
+
+	opts := []client.ListOption{client.InNamespace(namespace)}
+
+	if ref.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(ref.Selector)
+		if err != nil {
+			return nil, errors.WithMessage(err, "Invalid selector")
+		}
+		opts = append(opts, client.MatchingLabelsSelector{Selector: selector})
+	}
+
+	fieldSelector := fields.Everything()
+	if ref.FieldSelector != "" {
+		if fieldSelector, err = fields.ParseSelector(ref.FieldSelector); err != nil {
+			return nil, errors.WithMessage(err, "Invalid fieldSelector")
+		}
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+	if err := reader.List(ctx, list, opts...); err != nil {
+		return nil, err
+	}
+
+	// Informer caches only support indexed field selectors, so they are evaluated here
+	var items []unstructured.Unstructured
+	for _, item := range list.Items {
+		if matchesFields(fieldSelector, &item) {
+			items = append(items, item)
+		}
+	}
+
+	return pickSources(items, ref.Pick)
+}
+
+// matchesFields checks whether the fields of obj match the field selector.
+// Fields are addressed by their dot-delimited path, missing fields are treated as empty.
+func matchesFields(selector fields.Selector, obj *unstructured.Unstructured) bool {
+	if selector.Empty() {
+		return true
+	}
+
+	set := fields.Set{}
+	for _, requirement := range selector.Requirements() {
+		value, found, err := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(requirement.Field, ".")...)
+		if err == nil && found && value != nil {
+			set[requirement.Field] = fmt.Sprint(value)
+		} else {
+			set[requirement.Field] = ""
+		}
+	}
+
+	return selector.Matches(set)
+}
+
+// sourceKinds returns the kinds of all sources referenced by a DynamicResource
+func sourceKinds(dynamicResource *dynamickubev1alpha1.DynamicResource) map[schema.GroupVersionKind]struct{} {
+	gvks := map[schema.GroupVersionKind]struct{}{}
+	for _, ref := range sourceRefs(dynamicResource) {
+		gvks[schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)] = struct{}{}
+	}
+
+	return gvks
+}
+
+// pickSources selects the sources to use from the matched objects according to the pick policy
+func pickSources(items []unstructured.Unstructured, pick dynamickubev1alpha1.PickPolicy) ([]*unstructured.Unstructured, error) {
+	if len(items) == 0 {
+		return nil, errors.New("No source object matched the selectors")
+	}
+
+	sources := make([]*unstructured.Unstructured, 0, len(items))
+	for i := range items {
+		sources = append(sources, &items[i])
+	}
+
+	// Order by creation, objects created at the same time are ordered by name
+	sort.Slice(sources, func(i, j int) bool {
+		ti, tj := sources[i].GetCreationTimestamp(), sources[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return sources[i].GetName() < sources[j].GetName()
+	})
+
+	switch pick {
+	case dynamickubev1alpha1.PickSingle:
+		if len(sources) > 1 {
+			return nil, errors.New(fmt.Sprintf("Selectors matched %d source objects, but pick policy '%s' requires exactly one", len(sources), pick))
+		}
+		return sources, nil
+	case dynamickubev1alpha1.PickNewest:
+		return sources[len(sources)-1:], nil
+	case dynamickubev1alpha1.PickOldest:
+		return sources[:1], nil
+	case dynamickubev1alpha1.PickAll:
+		sort.Slice(sources, func(i, j int) bool {
+			return sources[i].GetName() < sources[j].GetName()
+		})
+		return sources, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown pick policy '%s'", pick))
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestPickSources(t *testing.T) {
+	created := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// ConfigMaps created the given number of seconds apart, in the given order
+	configMaps := func(names []string, seconds []int) []unstructured.Unstructured {
+		items := make([]unstructured.Unstructured, 0, len(names))
+		for i, name := range names {
+			u := newTestObject("v1", "ConfigMap", "default", name)
+			u.SetCreationTimestamp(metav1.NewTime(created.Add(time.Duration(seconds[i]) * time.Second)))
+			items = append(items, *u)
+		}
+		return items
+	}
+
+	// b and c are created at the same time, ties are ordered by name
+	names := []string{"c", "a", "d", "b"}
+	seconds := []int{1, 0, 2, 1}
+
+	tests := []struct {
+		pick     dynamickubev1alpha1.PickPolicy
+		expected []string
+	}{
+		{dynamickubev1alpha1.PickOldest, []string{"a"}},
+		{dynamickubev1alpha1.PickNewest, []string{"d"}},
+		{dynamickubev1alpha1.PickAll, []string{"a", "b", "c", "d"}},
+	}
+
+	for _, test := range tests {
+		sources, err := pickSources(configMaps(names, seconds), test.pick)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.pick, err)
+			continue
+		}
+
+		picked := make([]string, 0, len(sources))
+		for _, src := range sources {
+			picked = append(picked, src.GetName())
+		}
+		if !reflect.DeepEqual(picked, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.pick, test.expected, picked)
+		}
+	}
+
+	// Ties of the newest and oldest objects are broken by name, independent of the listed order
+	for _, order := range [][]string{{"x", "y"}, {"y", "x"}} {
+		oldest, _ := pickSources(configMaps(order, []int{0, 0}), dynamickubev1alpha1.PickOldest)
+		newest, _ := pickSources(configMaps(order, []int{0, 0}), dynamickubev1alpha1.PickNewest)
+		if oldest[0].GetName() != "x" || newest[0].GetName() != "y" {
+			t.Errorf("%v: expected oldest x and newest y, got %s and %s", order, oldest[0].GetName(), newest[0].GetName())
+		}
+	}
+
+	single, err := pickSources(configMaps([]string{"a"}, []int{0}), dynamickubev1alpha1.PickSingle)
+	if err != nil || len(single) != 1 || single[0].GetName() != "a" {
+		t.Errorf("unexpected result of pick Single: %v, %v", single, err)
+	}
+
+	for _, pick := range []dynamickubev1alpha1.PickPolicy{dynamickubev1alpha1.PickSingle, "Random"} {
+		if _, err := pickSources(configMaps(names, seconds), pick); err == nil {
+			t.Errorf("%s: expected error", pick)
+		}
+	}
+
+	if _, err := pickSources(nil, dynamickubev1alpha1.PickAll); err == nil {
+		t.Errorf("expected error without matched objects")
+	}
+}
+
+func TestMatchesFields(t *testing.T) {
+	obj := newTestObject("v1", "Secret", "default", "db")
+	obj.Object["type"] = "kubernetes.io/tls"
+	obj.Object["spec"] = map[string]interface{}{"replicas": int64(3), "paused": false}
+
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"type=kubernetes.io/tls", true},
+		{"type!=Opaque", true},
+		{"type=Opaque", false},
+		{"metadata.name=db,type=kubernetes.io/tls", true},
+		{"metadata.name=db,type=Opaque", false},
+		{"spec.replicas=3", true},
+		{"spec.paused=false", true},
+		// Missing fields are treated as empty
+		{"spec.missing=", true},
+		{"spec.missing!=value", true},
+		{"spec.missing=value", false},
+		// Fields below scalars are missing as well
+		{"type.name=", true},
+	}
+
+	for _, test := range tests {
+		selector, err := fields.ParseSelector(test.selector)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.selector, err)
+			continue
+		}
+
+		if matches := matchesFields(selector, obj); matches != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.selector, test.expected, matches)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
+)
+
+// listSelectorPattern matches path tokens selecting an item of a list of named objects, e.g. "containers[name=app]"
+var listSelectorPattern = regexp.MustCompile(`^(.+)\[([^=\]]+)=([^\]]*)\]$`)
+
+// injectValue writes value to the target field of the transformation and returns the path written
+func injectValue(u *unstructured.Unstructured, trans dynamickubev1alpha1.DynamicResourceTransformation, value interface{}) (string, error) {
+	if trans.TargetField != "" && trans.TargetPath != "" {
+		return "", errors.New("targetField and targetPath are mutually exclusive")
+	}
+
+	if trans.TargetPath != "" {
+		if err := setTargetPath(u.Object, trans.TargetPath, value); err != nil {
+			return "", err
+		}
+		return trans.TargetPath, nil
+	}
+
+	if trans.TargetField != "" {
+		spec := strings.Split(trans.TargetField, ".")
+		if err := unstructured.SetNestedField(u.Object, value, spec...); err != nil {
+			return "", err
+		}
+		return trans.TargetField, nil
+	}
+
+	return "", errors.New("one of targetField or targetPath is required")
+}
+
+// setTargetPath sets value at the JSON Pointer path of obj, creating missing maps and named list items
+func setTargetPath(obj map[string]interface{}, path string, value interface{}) error {
+	tokens, err := transform.ParseTargetPath(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = setPathValue(obj, tokens, runtime.DeepCopyJSONValue(value))
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Failed to set target path '%s'", path))
+	}
+
+	return nil
+}
+
+// setPathValue sets value at tokens below node and returns the updated node.
+// Nodes are returned, as appending to lists replaces them.
+func setPathValue(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token := tokens[0]
+
+	switch n := node.(type) {
+	case nil:
+		if token == "-" {
+			return setPathValue([]interface{}{}, tokens, value)
+		}
+		return setPathValue(map[string]interface{}{}, tokens, value)
+
+	case map[string]interface{}:
+		// Select an item of a list of named objects
+		if match := listSelectorPattern.FindStringSubmatch(token); match != nil {
+			key, field, name := match[1], match[2], match[3]
+
+			list, ok := n[key].([]interface{})
+			if !ok && n[key] != nil {
+				return nil, errors.New(fmt.Sprintf("'%s' is not a list", key))
+			}
+
+			updated, err := setNamedItem(list, field, name, tokens[1:], value)
+			if err != nil {
+				return nil, err
+			}
+
+			n[key] = updated
+			return n, nil
+		}
+
+		child, err := setPathValue(n[token], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		// Append to the list
+		if token == "-" {
+			item, err := setPathValue(nil, tokens[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			return append(n, item), nil
+		}
+
+		index, err := strconv.Atoi(token)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("'%s' is not a valid list index", token))
+		} else if index < 0 || index >= len(n) {
+			return nil, errors.New(fmt.Sprintf("List index %d is out of range", index))
+		}
+
+		item, err := setPathValue(n[index], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+
+		n[index] = item
+		return n, nil
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Cannot traverse '%s' of a %T", token, node))
+	}
+}
+
+// setNamedItem sets value at tokens below the list item whose field equals name.
+// Fields of other types than string, e.g. ports, are compared by their printed value.
+// A new item is appended if no item matches.
+func setNamedItem(list []interface{}, field, name string, tokens []string, value interface{}) ([]interface{}, error) {
+	for i, item := range list {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("List item %d is not an object and cannot be selected by '%s'", i, field))
+		}
+
+		itemName, found := itemMap[field]
+		if !found || fmt.Sprint(itemName) != name {
+			continue
+		}
+
+		updated, err := setPathValue(itemMap, tokens, value)
+		if err != nil {
+			return nil, err
+		}
+
+		list[i] = updated
+		return list, nil
+	}
+
+	item, err := setPathValue(map[string]interface{}{field: namedItemKey(list, field, name)}, tokens, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(list, item), nil
+}
+
+// namedItemKey converts the name of a new list item to the type the field has in the other items of the list
+func namedItemKey(list []interface{}, field, name string) interface{} {
+	for _, item := range list {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		switch itemMap[field].(type) {
+		case int64, float64:
+			if i, err := strconv.ParseInt(name, 10, 64); err == nil {
+				return i
+			}
+		case bool:
+			if b, err := strconv.ParseBool(name); err == nil {
+				return b
+			}
+		case nil:
+			continue
+		}
+
+		return name
+	}
+
+	return name
+}
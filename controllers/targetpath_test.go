@@ -0,0 +1,172 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestSetTargetPath(t *testing.T) {
+	deployment := func() map[string]interface{} {
+		return map[string]interface{}{
+			"spec": map[string]interface{}{
+				"args": []interface{}{"--verbose"},
+				"containers": []interface{}{
+					map[string]interface{}{"name": "app", "image": "nginx"},
+				},
+				"ports": []interface{}{
+					map[string]interface{}{"port": int64(80), "targetPort": int64(80)},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		value    interface{}
+		expected interface{}
+		at       []string
+	}{
+		{
+			name:     "create missing maps",
+			path:     "/metadata/annotations/app.kubernetes.io~1name",
+			value:    "web",
+			expected: map[string]interface{}{"app.kubernetes.io/name": "web"},
+			at:       []string{"metadata", "annotations"},
+		},
+		{
+			name:     "append to list",
+			path:     "/spec/args/-",
+			value:    "--port=80",
+			expected: []interface{}{"--verbose", "--port=80"},
+			at:       []string{"spec", "args"},
+		},
+		{
+			name:     "replace list item",
+			path:     "/spec/args/0",
+			value:    "--quiet",
+			expected: []interface{}{"--quiet"},
+			at:       []string{"spec", "args"},
+		},
+		{
+			name:  "update named item",
+			path:  "/spec/containers[name=app]/image",
+			value: "httpd",
+			expected: []interface{}{
+				map[string]interface{}{"name": "app", "image": "httpd"},
+			},
+			at: []string{"spec", "containers"},
+		},
+		{
+			name:  "create named item",
+			path:  "/spec/containers[name=sidecar]/image",
+			value: "envoy",
+			expected: []interface{}{
+				map[string]interface{}{"name": "app", "image": "nginx"},
+				map[string]interface{}{"name": "sidecar", "image": "envoy"},
+			},
+			at: []string{"spec", "containers"},
+		},
+		{
+			name:  "update item with numeric key",
+			path:  "/spec/ports[port=80]/targetPort",
+			value: int64(8080),
+			expected: []interface{}{
+				map[string]interface{}{"port": int64(80), "targetPort": int64(8080)},
+			},
+			at: []string{"spec", "ports"},
+		},
+		{
+			name:  "create item with numeric key",
+			path:  "/spec/ports[port=443]/targetPort",
+			value: int64(8443),
+			expected: []interface{}{
+				map[string]interface{}{"port": int64(80), "targetPort": int64(80)},
+				map[string]interface{}{"port": int64(443), "targetPort": int64(8443)},
+			},
+			at: []string{"spec", "ports"},
+		},
+		{
+			name:  "create named item in missing list",
+			path:  "/spec/volumes[name=data]/emptyDir",
+			value: map[string]interface{}{},
+			expected: []interface{}{
+				map[string]interface{}{"name": "data", "emptyDir": map[string]interface{}{}},
+			},
+			at: []string{"spec", "volumes"},
+		},
+	}
+
+	for _, test := range tests {
+		obj := deployment()
+		if err := setTargetPath(obj, test.path, test.value); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		value, _, _ := unstructured.NestedFieldNoCopy(obj, test.at...)
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, value)
+		}
+	}
+
+	for _, path := range []string{
+		"/spec/args/1",
+		"/spec/args/-1",
+		"/spec/args/first",
+		"/spec/args[name=app]/image",
+		"/spec/containers/0/name/first",
+		"spec/args",
+	} {
+		if err := setTargetPath(deployment(), path, "value"); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestInjectValue(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	path, err := injectValue(u, dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "data.password"}, "secret")
+	if err != nil || path != "data.password" {
+		t.Errorf("unexpected result %q, %v", path, err)
+	}
+	if value, _, _ := unstructured.NestedString(u.Object, "data", "password"); value != "secret" {
+		t.Errorf("expected value to be injected at targetField, got %q", value)
+	}
+
+	path, err = injectValue(u, dynamickubev1alpha1.DynamicResourceTransformation{TargetPath: "/data/tls.crt"}, "cert")
+	if err != nil || path != "/data/tls.crt" {
+		t.Errorf("unexpected result %q, %v", path, err)
+	}
+	if value, _, _ := unstructured.NestedString(u.Object, "data", "tls.crt"); value != "cert" {
+		t.Errorf("expected value to be injected at targetPath, got %q", value)
+	}
+
+	if _, err := injectValue(u, dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "a", TargetPath: "/a"}, "x"); err == nil {
+		t.Errorf("expected error for targetField and targetPath")
+	}
+	if _, err := injectValue(u, dynamickubev1alpha1.DynamicResourceTransformation{}, "x"); err == nil {
+		t.Errorf("expected error without targetField and targetPath")
+	}
+}
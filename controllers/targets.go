@@ -0,0 +1,96 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// targetObjects returns copies of the target resource definitions of the DynamicResource.
+// Targets without namespace are identified within the namespace of the DynamicResource,
+// so that no object is declared twice.
+func targetObjects(dynamicResource *dynamickubev1alpha1.DynamicResource) ([]*unstructured.Unstructured, error) {
+	if dynamicResource.Spec.Target != nil && len(dynamicResource.Spec.Targets) > 0 {
+		return nil, errors.New("target and targets are mutually exclusive")
+	}
+
+	if dynamicResource.Spec.Target != nil {
+		return []*unstructured.Unstructured{dynamicResource.Spec.Target.DeepCopy()}, nil
+	}
+
+	if len(dynamicResource.Spec.Targets) == 0 {
+		return nil, errors.New("one of target or targets is required")
+	}
+
+	targets := make([]*unstructured.Unstructured, 0, len(dynamicResource.Spec.Targets))
+	declared := map[string]int{}
+	for i, target := range dynamicResource.Spec.Targets {
+		namespace := target.GetNamespace()
+		if namespace == "" {
+			namespace = dynamicResource.Namespace
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", target.GroupVersionKind().GroupKind(), namespace, target.GetName())
+		if first, ok := declared[key]; ok {
+			return nil, errors.New(fmt.Sprintf("Targets %d and %d declare the same %s '%s/%s'", first, i, target.GetKind(), namespace, target.GetName()))
+		}
+		declared[key] = i
+
+		targets = append(targets, target.Unstructured.DeepCopy())
+	}
+
+	return targets, nil
+}
+
+// targetIndex returns the index of the target addressed by selector, which is either
+// the index or the name of the target. Transformations without selector write to the first target.
+func targetIndex(targets []*unstructured.Unstructured, selector *intstr.IntOrString) (int, error) {
+	if selector == nil {
+		return 0, nil
+	}
+
+	if selector.Type == intstr.Int {
+		index := selector.IntValue()
+		if index < 0 || index >= len(targets) {
+			return 0, errors.New(fmt.Sprintf("Target index %d is out of range", index))
+		}
+		return index, nil
+	}
+
+	index := -1
+	for i, target := range targets {
+		if target.GetName() != selector.StrVal {
+			continue
+		}
+		if index >= 0 {
+			return 0, errors.New(fmt.Sprintf("Target name '%s' is ambiguous, use the target index instead", selector.StrVal))
+		}
+		index = i
+	}
+
+	if index < 0 {
+		return 0, errors.New(fmt.Sprintf("No target named '%s'", selector.StrVal))
+	}
+
+	return index, nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestTargetObjects(t *testing.T) {
+	target := func(apiVersion, kind, namespace, name string) dynamickubev1alpha1.TargetObject {
+		return dynamickubev1alpha1.TargetObject{Unstructured: *newTestObject(apiVersion, kind, namespace, name)}
+	}
+
+	tests := []struct {
+		name    string
+		target  *unstructured.Unstructured
+		targets []dynamickubev1alpha1.TargetObject
+		names   []string
+		wantErr bool
+	}{
+		{name: "single target", target: newTestObject("v1", "Secret", "", "a"), names: []string{"a"}},
+		{
+			name:    "targets",
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a"), target("v1", "ConfigMap", "", "b")},
+			names:   []string{"a", "b"},
+		},
+		{
+			name:    "same name of different kinds",
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a"), target("v1", "ConfigMap", "", "a")},
+			names:   []string{"a", "a"},
+		},
+		{
+			name:    "same name in different namespaces",
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a"), target("v1", "Secret", "other", "a")},
+			names:   []string{"a", "a"},
+		},
+		{
+			name:    "same name of kinds in different groups",
+			targets: []dynamickubev1alpha1.TargetObject{target("example.com/v1", "Secret", "", "a"), target("v1", "Secret", "", "a")},
+			names:   []string{"a", "a"},
+		},
+		{
+			name:    "duplicate target",
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a"), target("v1", "Secret", "", "a")},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate target in the namespace of the DynamicResource",
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a"), target("v1", "ConfigMap", "", "b"), target("v1", "Secret", "default", "a")},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate target of another version",
+			targets: []dynamickubev1alpha1.TargetObject{target("apps/v1", "Deployment", "", "a"), target("apps/v1beta1", "Deployment", "", "a")},
+			wantErr: true,
+		},
+		{
+			name:    "target and targets",
+			target:  newTestObject("v1", "Secret", "", "a"),
+			targets: []dynamickubev1alpha1.TargetObject{target("v1", "ConfigMap", "", "b")},
+			wantErr: true,
+		},
+		{name: "no target", wantErr: true},
+	}
+
+	for _, test := range tests {
+		dynamicResource := &dynamickubev1alpha1.DynamicResource{}
+		dynamicResource.Namespace = "default"
+		dynamicResource.Spec.Target = test.target
+		dynamicResource.Spec.Targets = test.targets
+
+		targets, err := targetObjects(dynamicResource)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(targets) != len(test.names) {
+			t.Errorf("%s: expected %d targets, got %d", test.name, len(test.names), len(targets))
+			continue
+		}
+		for i, target := range targets {
+			if target.GetName() != test.names[i] {
+				t.Errorf("%s: expected target %d to be named '%s', got '%s'", test.name, i, test.names[i], target.GetName())
+			}
+		}
+	}
+
+	// Targets are copies, rendering must not modify the spec
+	dynamicResource := &dynamickubev1alpha1.DynamicResource{}
+	dynamicResource.Spec.Targets = []dynamickubev1alpha1.TargetObject{target("v1", "Secret", "", "a")}
+	targets, _ := targetObjects(dynamicResource)
+	targets[0].SetName("changed")
+	if dynamicResource.Spec.Targets[0].GetName() != "a" {
+		t.Errorf("expected targets to be copies of the spec")
+	}
+}
+
+func TestTargetIndex(t *testing.T) {
+	targets := []*unstructured.Unstructured{
+		newTestObject("v1", "Secret", "default", "credentials"),
+		newTestObject("v1", "ConfigMap", "default", "config"),
+		newTestObject("v1", "ConfigMap", "default", "credentials"),
+	}
+	intSelector := func(i int) *intstr.IntOrString {
+		selector := intstr.FromInt(i)
+		return &selector
+	}
+	nameSelector := func(name string) *intstr.IntOrString {
+		selector := intstr.FromString(name)
+		return &selector
+	}
+
+	tests := []struct {
+		name     string
+		selector *intstr.IntOrString
+		expected int
+		wantErr  bool
+	}{
+		{name: "no selector", selector: nil, expected: 0},
+		{name: "index", selector: intSelector(1), expected: 1},
+		{name: "last index", selector: intSelector(2), expected: 2},
+		{name: "index out of range", selector: intSelector(3), wantErr: true},
+		{name: "negative index", selector: intSelector(-1), wantErr: true},
+		{name: "name", selector: nameSelector("config"), expected: 1},
+		{name: "ambiguous name", selector: nameSelector("credentials"), wantErr: true},
+		{name: "missing name", selector: nameSelector("missing"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		index, err := targetIndex(targets, test.selector)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got index %d", test.name, index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if index != test.expected {
+			t.Errorf("%s: expected index %d, got %d", test.name, test.expected, index)
+		}
+	}
+}
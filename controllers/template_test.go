@@ -0,0 +1,106 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"user":     "admin",
+		"password": "cGFzcw==",
+		"empty":    "",
+		"none":     nil,
+		"zero":     int64(0),
+		"port":     int64(5432),
+		"config":   map[string]interface{}{"host": "db", "tags": []interface{}{"a", "b"}},
+		"list":     []interface{}{},
+		"padded":   "  value\n",
+		"lines":    "first\nsecond",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"value", "{{ .user }}:{{ .port }}", "admin:5432"},
+		{"nested value", "{{ .config.host }}", "db"},
+		{"b64enc", "{{ .user | b64enc }}", "YWRtaW4="},
+		{"b64dec", "{{ .password | b64dec }}", "pass"},
+		{"b64 round trip", "{{ .user | b64enc | b64dec }}", "admin"},
+		{"default of empty string", `{{ .empty | default "guest" }}`, "guest"},
+		{"default of nil", `{{ default "guest" .none }}`, "guest"},
+		{"default of zero", `{{ .zero | default 5432 }}`, "5432"},
+		{"default of empty list", `{{ .list | default "none" }}`, "none"},
+		{"default of value", `{{ .user | default "guest" }}`, "admin"},
+		// Optional keys of objects are looked up with index, which yields nil for missing keys
+		{"default of missing object key", `{{ index .config "port" | default 5432 }}`, "5432"},
+		{"upper", "{{ .user | upper }}", "ADMIN"},
+		{"trim", "[{{ .padded | trim }}]", "[value]"},
+		{"toJson", "{{ .config | toJson }}", `{"host":"db","tags":["a","b"]}`},
+		{"toJson of string", "{{ .user | toJson }}", `"admin"`},
+		{"sha256sum", "{{ .user | sha256sum }}", "8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918"},
+		{"indent", "config:\n{{ .lines | indent 2 }}", "config:\n  first\n  second"},
+		{"indent of number", "{{ .port | indent 1 }}", " 5432"},
+	}
+
+	for _, test := range tests {
+		rendered, err := renderTemplate(test.template, values)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if rendered != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, rendered)
+		}
+	}
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	values := map[string]interface{}{
+		"user":   "admin",
+		"config": map[string]interface{}{"host": "db"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		message  string
+	}{
+		// Missing keys are typos of source names, default does not apply to them
+		{"missing key", `{{ .usr }}`, `map has no entry for key "usr"`},
+		{"default of missing key", `{{ .usr | default "guest" }}`, `map has no entry for key "usr"`},
+		{"missing object key", `{{ .config.port | default 5432 }}`, `map has no entry for key "port"`},
+		{"b64dec of invalid value", `{{ .user | b64dec }}`, "b64dec"},
+		{"unknown function", `{{ .user | lower }}`, "Failed to parse template"},
+		{"unclosed action", `{{ .user `, "Failed to parse template"},
+	}
+
+	for _, test := range tests {
+		rendered, err := renderTemplate(test.template, values)
+		if err == nil {
+			t.Errorf("%s: expected error, got %q", test.name, rendered)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.message, err)
+		}
+	}
+}
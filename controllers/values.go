@@ -0,0 +1,91 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// convertValue converts a raw value read from an unstructured source object to the given value type
+func convertValue(value interface{}, valueType dynamickubev1alpha1.ValueType) (interface{}, error) {
+	switch valueType {
+	case dynamickubev1alpha1.ValueTypeAuto:
+		return runtime.DeepCopyJSONValue(value), nil
+
+	case dynamickubev1alpha1.ValueTypeInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, typeMismatch(value, valueType)
+			}
+			return int64(v), nil
+		case string:
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, typeMismatch(value, valueType)
+			}
+			return i, nil
+		}
+
+	case dynamickubev1alpha1.ValueTypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, typeMismatch(value, valueType)
+			}
+			return b, nil
+		}
+
+	case dynamickubev1alpha1.ValueTypeObject:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return runtime.DeepCopyJSONValue(value), nil
+		}
+
+	case dynamickubev1alpha1.ValueTypeJSON:
+		if v, ok := value.(string); ok {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(v), &decoded); err != nil {
+				return nil, errors.WithMessage(err, fmt.Sprintf("Value %q is not a valid JSON document", v))
+			}
+			return decoded, nil
+		}
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown value type '%s'", valueType))
+	}
+
+	return nil, typeMismatch(value, valueType)
+}
+
+// typeMismatch builds the error returned if a value cannot be converted to a value type
+func typeMismatch(value interface{}, valueType dynamickubev1alpha1.ValueType) error {
+	return errors.New(fmt.Sprintf("Cannot convert value %q of type %T to value type '%s'", fmt.Sprint(value), value, valueType))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestConvertValue(t *testing.T) {
+	object := map[string]interface{}{"host": "db", "ports": []interface{}{int64(5432)}}
+
+	tests := []struct {
+		name      string
+		value     interface{}
+		valueType dynamickubev1alpha1.ValueType
+		expected  interface{}
+	}{
+		{"Auto keeps strings", "5432", dynamickubev1alpha1.ValueTypeAuto, "5432"},
+		{"Auto keeps ints", int64(5432), dynamickubev1alpha1.ValueTypeAuto, int64(5432)},
+		{"Auto keeps objects", object, dynamickubev1alpha1.ValueTypeAuto, object},
+		{"Auto keeps nil", nil, dynamickubev1alpha1.ValueTypeAuto, nil},
+		{"Int from int", int64(3), dynamickubev1alpha1.ValueTypeInt, int64(3)},
+		{"Int from whole float", float64(3), dynamickubev1alpha1.ValueTypeInt, int64(3)},
+		{"Int from string", "-42", dynamickubev1alpha1.ValueTypeInt, int64(-42)},
+		{"Bool from bool", true, dynamickubev1alpha1.ValueTypeBool, true},
+		{"Bool from string", "false", dynamickubev1alpha1.ValueTypeBool, false},
+		{"Bool from short string", "1", dynamickubev1alpha1.ValueTypeBool, true},
+		{"Object from map", object, dynamickubev1alpha1.ValueTypeObject, object},
+		{"Object from list", []interface{}{"a", int64(1)}, dynamickubev1alpha1.ValueTypeObject, []interface{}{"a", int64(1)}},
+		{"JSON object", `{"host":"db","port":5432}`, dynamickubev1alpha1.ValueTypeJSON, map[string]interface{}{"host": "db", "port": int64(5432)}},
+		{"JSON list", `["a",true]`, dynamickubev1alpha1.ValueTypeJSON, []interface{}{"a", true}},
+		{"JSON scalar", `1.5`, dynamickubev1alpha1.ValueTypeJSON, 1.5},
+	}
+
+	for _, test := range tests {
+		converted, err := convertValue(test.value, test.valueType)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(converted, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, converted)
+		}
+	}
+
+	// Objects are copied, so that the source is not modified through the target
+	converted, _ := convertValue(object, dynamickubev1alpha1.ValueTypeObject)
+	converted.(map[string]interface{})["host"] = "changed"
+	if object["host"] != "db" {
+		t.Errorf("converted object shares its fields with the source")
+	}
+}
+
+func TestConvertValueErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		valueType dynamickubev1alpha1.ValueType
+	}{
+		{"Int from fraction", 1.5, dynamickubev1alpha1.ValueTypeInt},
+		{"Int from non-numeric string", "5432/TCP", dynamickubev1alpha1.ValueTypeInt},
+		{"Int from float string", "1.0", dynamickubev1alpha1.ValueTypeInt},
+		{"Int from bool", true, dynamickubev1alpha1.ValueTypeInt},
+		{"Int from nil", nil, dynamickubev1alpha1.ValueTypeInt},
+		{"Bool from string", "yes", dynamickubev1alpha1.ValueTypeBool},
+		{"Bool from int", int64(1), dynamickubev1alpha1.ValueTypeBool},
+		{"Object from string", `{"host":"db"}`, dynamickubev1alpha1.ValueTypeObject},
+		{"Object from int", int64(1), dynamickubev1alpha1.ValueTypeObject},
+		{"JSON from invalid document", `{"host":`, dynamickubev1alpha1.ValueTypeJSON},
+		{"JSON from object", map[string]interface{}{}, dynamickubev1alpha1.ValueTypeJSON},
+		{"unknown value type", "1", "Float"},
+	}
+
+	for _, test := range tests {
+		if converted, err := convertValue(test.value, test.valueType); err == nil {
+			t.Errorf("%s: expected error, got %#v", test.name, converted)
+		}
+	}
+}
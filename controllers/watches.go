@@ -0,0 +1,228 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// sourceIndexKey is the field index that maps source objects back to the DynamicResources reading them
+const sourceIndexKey = ".spec.transformations.fieldFrom"
+
+// anySourceName is indexed in place of the name for sources matched by selectors
+const anySourceName = "*"
+
+// sourceKey builds the index value identifying a single source object
+func sourceKey(gk schema.GroupKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gk.String(), namespace, name)
+}
+
+// indexSources returns the keys of all source objects referenced by a DynamicResource
+func (r *DynamicResourceReconciler) indexSources(obj client.Object) []string {
+	dynamicResource, ok := obj.(*dynamickubev1alpha1.DynamicResource)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, ref := range sourceRefs(dynamicResource) {
+		gk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind()
+
+		// Kinds that are not known (yet) are indexed as namespaced
+		namespace, err := sourceNamespace(r.RESTMapper(), dynamicResource, ref)
+		if err != nil {
+			namespace = dynamicResource.Namespace
+		}
+
+		name := ref.Name
+		if name == "" {
+			name = anySourceName
+		}
+
+		keys = append(keys, sourceKey(gk, namespace, name))
+	}
+
+	return keys
+}
+
+// watchSource watches the events of a source informer.
+// Events on source objects are mapped to the DynamicResources reading them.
+func (r *DynamicResourceReconciler) watchSource(informer cache.Informer) error {
+	return r.controller.Watch(&source.Informer{Informer: informer}, handler.EnqueueRequestsFromMapFunc(r.requestsForSource))
+}
+
+// watchTarget starts watching the given target kind, unless it is already being watched.
+// Events on target objects are mapped to the DynamicResource controlling them.
+func (r *DynamicResourceReconciler) watchTarget(gvk schema.GroupVersionKind) error {
+	return r.watch(r.targetWatches, gvk, handler.EnqueueRequestsFromMapFunc(requestsForTarget))
+}
+
+// watch adds a watch for the given kind to the controller and records it in watches
+func (r *DynamicResourceReconciler) watch(watches map[schema.GroupVersionKind]struct{}, gvk schema.GroupVersionKind, eventHandler handler.EventHandler) error {
+	r.watchesLock.Lock()
+	defer r.watchesLock.Unlock()
+
+	if _, ok := watches[gvk]; ok {
+		return nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	err := r.controller.Watch(&source.Kind{Type: obj}, eventHandler)
+	if err != nil {
+		return err
+	}
+
+	watches[gvk] = struct{}{}
+
+	return nil
+}
+
+// requestsForSource maps a source object to reconcile requests for all DynamicResources reading it
+func (r *DynamicResourceReconciler) requestsForSource(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	logger := log.FromContext(ctx)
+
+	gk := obj.GetObjectKind().GroupVersionKind().GroupKind()
+	var requests []reconcile.Request
+
+	// DynamicResources referencing the source by name
+	key := sourceKey(gk, obj.GetNamespace(), obj.GetName())
+
+	var dynamicResources dynamickubev1alpha1.DynamicResourceList
+	if err := r.List(ctx, &dynamicResources, client.MatchingFields{sourceIndexKey: key}); err != nil {
+		logger.Error(err, "Failed to list DynamicResources for source object", "source", key)
+		return nil
+	}
+
+	for _, item := range dynamicResources.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+
+	// DynamicResources matching sources of this kind by selectors
+	key = sourceKey(gk, obj.GetNamespace(), anySourceName)
+
+	var matchingDynamicResources dynamickubev1alpha1.DynamicResourceList
+	if err := r.List(ctx, &matchingDynamicResources, client.MatchingFields{sourceIndexKey: key}); err != nil {
+		logger.Error(err, "Failed to list DynamicResources for source object", "source", key)
+		return nil
+	}
+
+	for _, item := range matchingDynamicResources.Items {
+		if selectsSource(&item, gk, obj) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+		}
+	}
+
+	return requests
+}
+
+// selectsSource checks whether the label selector of any transformation of the DynamicResource matches obj.
+// Field selectors are not evaluated, so objects only matched by them are always considered.
+func selectsSource(dynamicResource *dynamickubev1alpha1.DynamicResource, gk schema.GroupKind, obj client.Object) bool {
+	for _, ref := range sourceRefs(dynamicResource) {
+		if ref.Name != "" || schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind() != gk {
+			continue
+		}
+
+		if ref.Selector == nil {
+			return true
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(ref.Selector)
+		if err != nil {
+			continue
+		}
+
+		if selector.Matches(labels.Set(obj.GetLabels())) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestsForTarget maps a target object to the DynamicResource controlling it, either through its controller
+// owner reference or, for cluster-scoped and cross-namespace targets, through its ownership labels
+func requestsForTarget(obj client.Object) []reconcile.Request {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		if owner.Kind != "DynamicResource" || owner.APIVersion != dynamickubev1alpha1.GroupVersion.String() {
+			return nil
+		}
+
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: obj.GetNamespace(), Name: owner.Name}}}
+	}
+
+	if key, ok := labelOwner(obj); ok {
+		return []reconcile.Request{{NamespacedName: key}}
+	}
+
+	return nil
+}
+
+// requestsForPolicy maps a DynamicResourcePolicy to reconcile requests for all DynamicResources,
+// as changes of the policy or its namespace selector may affect any of them
+func (r *DynamicResourceReconciler) requestsForPolicy(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	var dynamicResources dynamickubev1alpha1.DynamicResourceList
+	if err := r.List(ctx, &dynamicResources); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list DynamicResources for policy", "policy", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(dynamicResources.Items))
+	for _, item := range dynamicResources.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+
+	return requests
+}
+
+// requestsForNamespace maps a Namespace to reconcile requests for the DynamicResources in it,
+// as changes of its labels may change the DynamicResourcePolicies that apply to them
+func (r *DynamicResourceReconciler) requestsForNamespace(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+
+	var dynamicResources dynamickubev1alpha1.DynamicResourceList
+	if err := r.List(ctx, &dynamicResources, client.InNamespace(obj.GetName())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list DynamicResources for namespace", "namespace", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(dynamicResources.Items))
+	for _, item := range dynamicResources.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+
+	return requests
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sensitive
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+)
+
+// Logger returns a logger that redacts the sensitive values known to the Tracker
+// from the messages, values and errors logged through it
+func Logger(logger logr.Logger, t *Tracker) logr.Logger {
+	return logr.New(&redactingSink{sink: logger.GetSink(), tracker: t})
+}
+
+// redactingSink is a logr.LogSink that redacts sensitive values before passing entries on
+type redactingSink struct {
+	sink    logr.LogSink
+	tracker *Tracker
+}
+
+var _ logr.CallDepthLogSink = &redactingSink{}
+
+// Init implements logr.LogSink
+func (s *redactingSink) Init(info logr.RuntimeInfo) {
+	// The wrapping sink adds a frame between the caller and the wrapped sink
+	info.CallDepth++
+	s.sink.Init(info)
+}
+
+// Enabled implements logr.LogSink
+func (s *redactingSink) Enabled(level int) bool {
+	return s.sink.Enabled(level)
+}
+
+// Info implements logr.LogSink
+func (s *redactingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink.Info(level, s.tracker.Redact(msg), s.redactValues(keysAndValues)...)
+}
+
+// Error implements logr.LogSink
+func (s *redactingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(s.tracker.RedactError(err), s.tracker.Redact(msg), s.redactValues(keysAndValues)...)
+}
+
+// WithValues implements logr.LogSink
+func (s *redactingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithValues(s.redactValues(keysAndValues)...), tracker: s.tracker}
+}
+
+// WithName implements logr.LogSink
+func (s *redactingSink) WithName(name string) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithName(name), tracker: s.tracker}
+}
+
+// WithCallDepth implements logr.CallDepthLogSink
+func (s *redactingSink) WithCallDepth(depth int) logr.LogSink {
+	if sink, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return &redactingSink{sink: sink.WithCallDepth(depth), tracker: s.tracker}
+	}
+
+	return s
+}
+
+// redactValues redacts the values of key/value pairs. Values other than strings and errors
+// are formatted first, so that sensitive values nested in them are redacted as well.
+func (s *redactingSink) redactValues(keysAndValues []interface{}) []interface{} {
+	redacted := make([]interface{}, len(keysAndValues))
+
+	for i, value := range keysAndValues {
+		// Keys are passed on as they are
+		if i%2 == 0 {
+			redacted[i] = value
+			continue
+		}
+
+		switch v := value.(type) {
+		case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			redacted[i] = v
+		case string:
+			redacted[i] = s.tracker.Redact(v)
+		case error:
+			redacted[i] = s.tracker.RedactError(v)
+		case fmt.Stringer:
+			redacted[i] = s.tracker.Redact(v.String())
+		default:
+			formatted := fmt.Sprintf("%v", v)
+			if r := s.tracker.Redact(formatted); r != formatted {
+				redacted[i] = r
+			} else {
+				redacted[i] = v
+			}
+		}
+	}
+
+	return redacted
+}
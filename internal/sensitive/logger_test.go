@@ -0,0 +1,57 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sensitive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestLogger(t *testing.T) {
+	var lines []string
+	base := funcr.New(func(prefix, args string) {
+		lines = append(lines, prefix+" "+args)
+	}, funcr.Options{})
+
+	tracker := NewTracker()
+	logger := Logger(base, tracker).WithName("controller").WithValues("resource", "default/sample")
+
+	// Values tracked after the logger was created are redacted as well
+	tracker.Track(secret)
+
+	logger.Info("Resolved value "+secret, "value", secret, "values", []interface{}{secret}, "count", 1)
+	logger.Error(fmt.Errorf("cannot convert %q to Int", secret), "Failed to reconcile", "field", secret)
+	logger.WithValues("cached", secret).Info("Done")
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %d", len(lines))
+	}
+
+	for _, line := range lines {
+		assertRedacted(t, line, secret)
+		if !strings.Contains(line, "default/sample") {
+			t.Errorf("log line %q lost its values", line)
+		}
+	}
+
+	if !strings.Contains(lines[0], Hash(secret)) || !strings.Contains(lines[0], `"count"=1`) {
+		t.Errorf("unexpected log line %q", lines[0])
+	}
+}
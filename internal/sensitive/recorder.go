@@ -0,0 +1,50 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sensitive
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Recorder returns an event recorder that redacts the sensitive values known to the Tracker from event messages
+func Recorder(recorder record.EventRecorder, t *Tracker) record.EventRecorder {
+	return &redactingRecorder{recorder: recorder, tracker: t}
+}
+
+// redactingRecorder is a record.EventRecorder that redacts sensitive values before recording events
+type redactingRecorder struct {
+	recorder record.EventRecorder
+	tracker  *Tracker
+}
+
+// Event implements record.EventRecorder
+func (r *redactingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.recorder.Event(object, eventtype, reason, r.tracker.Redact(message))
+}
+
+// Eventf implements record.EventRecorder
+func (r *redactingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.recorder.Event(object, eventtype, reason, r.tracker.Redact(fmt.Sprintf(messageFmt, args...)))
+}
+
+// AnnotatedEventf implements record.EventRecorder
+func (r *redactingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", r.tracker.Redact(fmt.Sprintf(messageFmt, args...)))
+}
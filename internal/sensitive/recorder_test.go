@@ -0,0 +1,52 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sensitive
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecorder(t *testing.T) {
+	fake := record.NewFakeRecorder(3)
+
+	tracker := NewTracker()
+	tracker.Track(secret)
+	recorder := Recorder(fake, tracker)
+
+	object := &corev1.Secret{}
+	recorder.Event(object, corev1.EventTypeNormal, "Applied", "Applied "+secret)
+	recorder.Eventf(object, corev1.EventTypeNormal, "DriftCorrected", "Reverted %s", secret)
+	recorder.AnnotatedEventf(object, map[string]string{"a": "b"}, corev1.EventTypeWarning, "Failed", "Failed with %q", secret)
+	close(fake.Events)
+
+	count := 0
+	for event := range fake.Events {
+		count++
+		assertRedacted(t, event, secret)
+		if !strings.Contains(event, Hash(secret)) {
+			t.Errorf("event %q does not contain the hash", event)
+		}
+	}
+
+	if count != 3 {
+		t.Errorf("expected 3 events, got %d", count)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sensitive keeps track of sensitive values, e.g. read from Secrets, and redacts them from
+// messages that leave the controller, like log entries, events and status fields.
+package sensitive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minSubstringLength is the length from which sensitive values are redacted wherever they appear in a message.
+// Shorter values, like "1", "true" or "pw", would match parts of unrelated words, field paths or hashes,
+// so they are only redacted where they form a token of their own.
+const minSubstringLength = 8
+
+// hashKey is the random key of the HMAC used by Hash. It is generated per process, so that the
+// hashes cannot be matched against precomputed hashes of guessed values, e.g. short numbers.
+var hashKey = newHashKey()
+
+func newHashKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("Failed to generate key for redaction hashes: %v", err))
+	}
+
+	return key
+}
+
+// Hash returns the redacted form of a sensitive value, which allows to tell values apart without revealing them.
+// Hashes are only comparable within the same process.
+func Hash(value string) string {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(value))
+
+	return fmt.Sprintf("<redacted hmac:%x>", mac.Sum(nil)[:8])
+}
+
+// Tracker records sensitive values and redacts them from messages.
+// All methods are safe to call on a nil Tracker, which does not redact anything.
+type Tracker struct {
+	lock sync.Mutex
+
+	// redactions maps every known representation of a sensitive value to its hash
+	redactions map[string]string
+
+	// candidates holds the representations by their first byte, longest first
+	candidates map[byte][]string
+}
+
+// NewTracker creates a Tracker without sensitive values
+func NewTracker() *Tracker {
+	return &Tracker{redactions: map[string]string{}}
+}
+
+// Track marks value as sensitive. Strings nested in maps and lists are tracked individually,
+// other scalar values are not tracked. Besides the value itself, its quoted, JSON escaped and
+// base64 encoded representations are redacted, as well as the decoded value of base64 strings.
+func (t *Tracker) Track(value interface{}) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.track(value)
+}
+
+func (t *Tracker) track(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		t.trackString(v)
+	case []byte:
+		t.trackString(string(v))
+	case map[string]interface{}:
+		for _, item := range v {
+			t.track(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			t.track(item)
+		}
+	}
+}
+
+func (t *Tracker) trackString(value string) {
+	if value == "" {
+		return
+	}
+
+	t.addRepresentations(value)
+
+	// Secret data is base64 encoded, its plaintext is just as sensitive
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) > 0 && utf8.Valid(decoded) {
+		t.addRepresentations(string(decoded))
+	}
+}
+
+// addRepresentations records the representations of value in which it may appear in messages
+func (t *Tracker) addRepresentations(value string) {
+	hash := Hash(value)
+
+	quoted := strconv.Quote(value)
+	escaped, _ := json.Marshal(value)
+
+	for _, representation := range []string{
+		value,
+		quoted[1 : len(quoted)-1],
+		string(escaped[1 : len(escaped)-1]),
+		base64.StdEncoding.EncodeToString([]byte(value)),
+	} {
+		if _, ok := t.redactions[representation]; !ok {
+			t.redactions[representation] = hash
+			t.candidates = nil
+		}
+	}
+}
+
+// Redact replaces all sensitive values in message by their hash.
+// Values shorter than minSubstringLength are only replaced where they are not part of a longer token.
+func (t *Tracker) Redact(message string) string {
+	if t == nil {
+		return message
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.redactions) == 0 {
+		return message
+	}
+
+	if t.candidates == nil {
+		// Longer values take precedence, so that values containing other values are redacted as a whole
+		values := make([]string, 0, len(t.redactions))
+		for value := range t.redactions {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if len(values[i]) != len(values[j]) {
+				return len(values[i]) > len(values[j])
+			}
+			return values[i] < values[j]
+		})
+
+		t.candidates = map[byte][]string{}
+		for _, value := range values {
+			t.candidates[value[0]] = append(t.candidates[value[0]], value)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(message); {
+		value, ok := t.match(message, i)
+		if !ok {
+			b.WriteByte(message[i])
+			i++
+			continue
+		}
+
+		b.WriteString(t.redactions[value])
+		i += len(value)
+	}
+
+	return b.String()
+}
+
+// match returns the longest sensitive value at position i of message
+func (t *Tracker) match(message string, i int) (string, bool) {
+	for _, value := range t.candidates[message[i]] {
+		if !strings.HasPrefix(message[i:], value) {
+			continue
+		}
+
+		if len(value) >= minSubstringLength || (!tokenBefore(message, i) && !tokenAfter(message, i+len(value))) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// tokenBefore checks whether the character before position i of message continues a token
+func tokenBefore(message string, i int) bool {
+	r, size := utf8.DecodeLastRuneInString(message[:i])
+	return size > 0 && isTokenRune(r)
+}
+
+// tokenAfter checks whether the character at position i of message continues a token
+func tokenAfter(message string, i int) bool {
+	r, size := utf8.DecodeRuneInString(message[i:])
+	return size > 0 && isTokenRune(r)
+}
+
+// isTokenRune checks whether r is part of words, numbers or field paths like "data.pw" or "app-config/pw"
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-/", r)
+}
+
+// RedactError returns an error with the message of err, in which all sensitive values are replaced by their hash
+func (t *Tracker) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	redacted := t.Redact(message)
+	if redacted == message {
+		return err
+	}
+
+	return errors.New(redacted)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries the Tracker
+func NewContext(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the Tracker carried by ctx, or nil if there is none
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
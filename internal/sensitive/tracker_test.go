@@ -0,0 +1,190 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sensitive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const secret = "s3cr3t-p@ss\"word\n"
+
+// assertRedacted fails if message contains any representation of value
+func assertRedacted(t *testing.T, message, value string) {
+	t.Helper()
+
+	for _, plaintext := range []string{
+		value,
+		fmt.Sprintf("%q", value)[1 : len(fmt.Sprintf("%q", value))-1],
+		base64.StdEncoding.EncodeToString([]byte(value)),
+	} {
+		if strings.Contains(message, plaintext) {
+			t.Errorf("message %q contains plaintext %q", message, plaintext)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash := Hash(secret)
+	if hash != Hash(secret) {
+		t.Errorf("hash of the same value differs")
+	}
+	if hash == Hash("other") {
+		t.Errorf("hash of different values is equal")
+	}
+	if !strings.HasPrefix(hash, "<redacted hmac:") || len(hash) != len("<redacted hmac:")+17 {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+	assertRedacted(t, hash, secret)
+
+	// The hash is keyed, so it cannot be looked up from the plain sha256 of a guessed value
+	if plain := fmt.Sprintf("%x", sha256.Sum256([]byte(secret))); strings.Contains(hash, plain[:16]) {
+		t.Errorf("hash %q is the unkeyed sha256 of the value", hash)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Track(secret)
+
+	for _, message := range []string{
+		"value " + secret + " is invalid",
+		fmt.Sprintf("cannot convert %q to Int", secret),
+		fmt.Sprintf(`{"password":%q}`, secret),
+		"encoded: " + base64.StdEncoding.EncodeToString([]byte(secret)),
+	} {
+		redacted := tracker.Redact(message)
+		assertRedacted(t, redacted, secret)
+		if !strings.Contains(redacted, Hash(secret)) {
+			t.Errorf("redacted message %q does not contain the hash", redacted)
+		}
+	}
+
+	if message := "nothing to see"; tracker.Redact(message) != message {
+		t.Errorf("message without sensitive values was changed")
+	}
+}
+
+func TestTrackBase64(t *testing.T) {
+	// Secret data is read in its encoded form
+	tracker := NewTracker()
+	tracker.Track(base64.StdEncoding.EncodeToString([]byte(secret)))
+
+	assertRedacted(t, tracker.Redact("decoded "+secret), secret)
+	assertRedacted(t, tracker.Redact("encoded "+base64.StdEncoding.EncodeToString([]byte(secret))), secret)
+}
+
+func TestTrackNested(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Track(map[string]interface{}{
+		"username": "admin-user",
+		"hosts":    []interface{}{"db-0.internal", int64(5432)},
+		"empty":    "",
+	})
+
+	message := tracker.Redact(fmt.Sprintf("%v", map[string]interface{}{"user": "admin-user", "host": "db-0.internal"}))
+	assertRedacted(t, message, "admin-user")
+	assertRedacted(t, message, "db-0.internal")
+
+	// Empty strings and other scalars are not tracked
+	if message := "port 5432 is empty"; tracker.Redact(message) != message {
+		t.Errorf("message without sensitive values was changed to %q", tracker.Redact(message))
+	}
+}
+
+func TestRedactOverlapping(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Track("abc")
+	tracker.Track("abcdef")
+
+	redacted := tracker.Redact("abcdef")
+	if redacted != Hash("abcdef") {
+		t.Errorf("expected the longer value to be redacted as a whole, got %q", redacted)
+	}
+}
+
+func TestRedactShortValues(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Track("pw")
+	tracker.Track("1")
+	tracker.Track("true")
+
+	// Short values are redacted where they form a token of their own
+	for message, expected := range map[string]string{
+		"value pw is invalid":           "value " + Hash("pw") + " is invalid",
+		`cannot convert "true" to Int`:  `cannot convert "` + Hash("true") + `" to Int`,
+		"replicas=1, enabled: true":     "replicas=" + Hash("1") + ", enabled: " + Hash("true"),
+		`{"password":"pw"}`:             `{"password":"` + Hash("pw") + `"}`,
+		"pw":                            Hash("pw"),
+		"fields differ: data.pw":        "fields differ: data.pw",
+		"pwd, 10 replicas, untrue, v1":  "pwd, 10 replicas, untrue, v1",
+		"secret app-pw/pw-1 is missing": "secret app-pw/pw-1 is missing",
+	} {
+		if redacted := tracker.Redact(message); redacted != expected {
+			t.Errorf("expected %q to be redacted to %q, got %q", message, expected, redacted)
+		}
+	}
+
+	// Hashes inserted for other values are not redacted again
+	tracker.Track("hmac")
+	if redacted := tracker.Redact("hmac"); redacted != Hash("hmac") {
+		t.Errorf("expected the hash of the value, got %q", redacted)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Track(secret)
+
+	if tracker.RedactError(nil) != nil {
+		t.Errorf("nil error was not kept")
+	}
+
+	err := errors.WithMessage(fmt.Errorf("cannot convert %q to Int", secret), "Source 'password'")
+	assertRedacted(t, tracker.RedactError(err).Error(), secret)
+
+	plain := errors.New("not found")
+	if tracker.RedactError(plain) != plain {
+		t.Errorf("error without sensitive values was replaced")
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	tracker.Track(secret)
+
+	if tracker.Redact(secret) != secret {
+		t.Errorf("nil tracker changed the message")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Errorf("expected no tracker in an empty context")
+	}
+
+	tracker := NewTracker()
+	if FromContext(NewContext(context.Background(), tracker)) != tracker {
+		t.Errorf("tracker was not carried by the context")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/ext"
+	"github.com/pkg/errors"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// MaxCELExpressionLength is the maximum number of characters of a CEL expression
+const MaxCELExpressionLength = 2048
+
+// CompileCEL compiles a CEL expression, in which the named sources are available as variables.
+// Sources are declared with the CEL type of their valueType, values of the Auto, Object and JSON types
+// and sources without valueType are dynamically typed. Sources picking all matches are declared as lists.
+// Besides the standard definitions, the string and base64 extensions of cel-go are available.
+// cel-go does not limit the cost of an evaluation, so the length of expressions is limited and macros
+// iterating over lists or maps (e.g. map, filter or exists) cannot be nested. The cost of an evaluation
+// is therefore bounded by the size of the expression and the source values.
+func CompileCEL(expression string, sources map[string]dynamickubev1alpha1.ExternalFieldRef) (cel.Program, error) {
+	if length := utf8.RuneCountInString(expression); length > MaxCELExpressionLength {
+		return nil, errors.New(fmt.Sprintf("CEL expression has %d characters, at most %d are allowed", length, MaxCELExpressionLength))
+	}
+
+	declarations := make([]*exprpb.Decl, 0, len(sources))
+	for name, ref := range sources {
+		declarations = append(declarations, decls.NewVar(name, celType(ref)))
+	}
+
+	env, err := cel.NewEnv(cel.Declarations(declarations...), ext.Strings(), ext.Encoders())
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to create CEL environment")
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, errors.WithMessage(issues.Err(), "Failed to compile CEL expression")
+	}
+
+	if comprehensionDepth(ast.Expr()) > 1 {
+		return nil, errors.New("Failed to compile CEL expression: macros iterating over lists or maps cannot be nested")
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to compile CEL expression")
+	}
+
+	return program, nil
+}
+
+// celType returns the CEL type of the values read from a source
+func celType(ref dynamickubev1alpha1.ExternalFieldRef) *exprpb.Type {
+	var t *exprpb.Type
+	switch ref.ValueType {
+	case dynamickubev1alpha1.ValueTypeString:
+		t = decls.String
+	case dynamickubev1alpha1.ValueTypeInt:
+		t = decls.Int
+	case dynamickubev1alpha1.ValueTypeBool:
+		t = decls.Bool
+	default:
+		t = decls.Dyn
+	}
+
+	if ref.Pick == dynamickubev1alpha1.PickAll {
+		return decls.NewListType(t)
+	}
+
+	return t
+}
+
+// comprehensionDepth returns the maximum number of nested comprehensions, the expansion of iterating macros, in an
+// expression. Only comprehensions in the loop of another comprehension count as nested, as they are evaluated repeatedly.
+func comprehensionDepth(expr *exprpb.Expr) int {
+	if expr == nil {
+		return 0
+	}
+
+	depth := 0
+	deeper := func(exprs ...*exprpb.Expr) {
+		for _, e := range exprs {
+			if d := comprehensionDepth(e); d > depth {
+				depth = d
+			}
+		}
+	}
+
+	switch e := expr.ExprKind.(type) {
+	case *exprpb.Expr_SelectExpr:
+		deeper(e.SelectExpr.Operand)
+	case *exprpb.Expr_CallExpr:
+		deeper(e.CallExpr.Target)
+		deeper(e.CallExpr.Args...)
+	case *exprpb.Expr_ListExpr:
+		deeper(e.ListExpr.Elements...)
+	case *exprpb.Expr_StructExpr:
+		for _, entry := range e.StructExpr.Entries {
+			deeper(entry.GetMapKey(), entry.Value)
+		}
+	case *exprpb.Expr_ComprehensionExpr:
+		c := e.ComprehensionExpr
+		deeper(c.LoopCondition, c.LoopStep)
+		depth++
+		deeper(c.IterRange, c.AccuInit, c.Result)
+	}
+
+	return depth
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+func TestCompileCEL(t *testing.T) {
+	source := func(valueType dynamickubev1alpha1.ValueType, pick dynamickubev1alpha1.PickPolicy) dynamickubev1alpha1.ExternalFieldRef {
+		return dynamickubev1alpha1.ExternalFieldRef{
+			TypeMeta:  metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			Name:      "config",
+			Pick:      pick,
+			FieldSpec: "{.data.value}",
+			ValueType: valueType,
+		}
+	}
+
+	sources := map[string]dynamickubev1alpha1.ExternalFieldRef{
+		"replicas": source(dynamickubev1alpha1.ValueTypeInt, ""),
+		"enabled":  source(dynamickubev1alpha1.ValueTypeBool, ""),
+		"tier":     source(dynamickubev1alpha1.ValueTypeString, ""),
+		"names":    source(dynamickubev1alpha1.ValueTypeString, dynamickubev1alpha1.PickAll),
+		"config":   source("", ""),
+		"labels":   source(dynamickubev1alpha1.ValueTypeObject, ""),
+	}
+
+	valid := []string{
+		"replicas * 2",
+		"enabled ? tier : 'none'",
+		"names.exists(n, n == tier)",
+		"names.filter(n, n != '').map(n, n.upperAscii())",
+		"config + 1",
+		"labels.app",
+		"'" + strings.Repeat("a", MaxCELExpressionLength-2) + "'",
+	}
+	for _, expression := range valid {
+		if _, err := CompileCEL(expression, sources); err != nil {
+			t.Errorf("%.40s: unexpected error: %v", expression, err)
+		}
+	}
+
+	invalid := []string{
+		"replicas + '1'",
+		"enabled + 1",
+		"tier * 2",
+		"names + 1",
+		"names.map(n, names.filter(m, m == n))",
+		"names.all(n, names.exists(m, m == n))",
+		"'" + strings.Repeat("a", MaxCELExpressionLength-1) + "'",
+	}
+	for _, expression := range invalid {
+		if _, err := CompileCEL(expression, sources); err == nil {
+			t.Errorf("%.40s: expected error", expression)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transform parses the expressions used by DynamicResource transformations. It is shared by the
+// controller, which evaluates them, and the webhooks, which reject invalid expressions at admission time.
+package transform
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/kubectl/pkg/cmd/get"
+)
+
+// ParseFieldSpec parses a FieldSpec, which is a JSONPath expression as accepted by kubectl
+func ParseFieldSpec(fieldSpec string) (*jsonpath.JSONPath, error) {
+	// Parse jsonpath
+	// https://kubernetes.io/docs/reference/kubectl/jsonpath/
+	fields, err := NormalizeFieldSpec(fieldSpec)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Invalid FieldSpec (needs to be a valid jsonpath)")
+	}
+
+	j := jsonpath.New("")
+	err = j.Parse(fields)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to parse FieldSpec (needs to be a valid jsonpath)")
+	}
+
+	return j, nil
+}
+
+// NormalizeFieldSpec converts a FieldSpec to the {...} form, e.g. data.password becomes {.data.password}
+func NormalizeFieldSpec(fieldSpec string) (string, error) {
+	return get.RelaxedJSONPathExpression(fieldSpec)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTargetPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []string
+	}{
+		{"/data/tls.crt", []string{"data", "tls.crt"}},
+		{"/metadata/annotations/app.kubernetes.io~1name", []string{"metadata", "annotations", "app.kubernetes.io/name"}},
+		{"/data/a~0b", []string{"data", "a~b"}},
+		// ~01 is the escaped form of ~1, not of /
+		{"/data/~01", []string{"data", "~1"}},
+		{"/spec/args/-", []string{"spec", "args", "-"}},
+		{"/", []string{""}},
+	}
+
+	for _, test := range tests {
+		tokens, err := ParseTargetPath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.path, test.expected, tokens)
+		}
+	}
+
+	for _, path := range []string{"", "data/tls.crt"} {
+		if _, err := ParseTargetPath(path); err == nil {
+			t.Errorf("%q: expected error for path without leading /", path)
+		}
+	}
+}
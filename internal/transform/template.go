@@ -0,0 +1,109 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// templateFuncs is the function library available in transformation templates
+var templateFuncs = template.FuncMap{
+	"b64enc": func(value interface{}) string {
+		return base64.StdEncoding.EncodeToString([]byte(toString(value)))
+	},
+	"b64dec": func(value interface{}) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(toString(value))
+		if err != nil {
+			return "", errors.WithMessage(err, "b64dec")
+		}
+		return string(decoded), nil
+	},
+	"default": func(def interface{}, value ...interface{}) interface{} {
+		if len(value) == 0 || isEmpty(value[0]) {
+			return def
+		}
+		return value[0]
+	},
+	"upper": func(value interface{}) string {
+		return strings.ToUpper(toString(value))
+	},
+	"trim": func(value interface{}) string {
+		return strings.TrimSpace(toString(value))
+	},
+	"toJson": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", errors.WithMessage(err, "toJson")
+		}
+		return string(data), nil
+	},
+	"sha256sum": func(value interface{}) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(toString(value))))
+	},
+	"indent": func(spaces int, value interface{}) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(toString(value), "\n", "\n"+pad)
+	},
+}
+
+// ParseTemplate parses a transformation template with the function library available in templates.
+// Rendering fails for keys missing from the values.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to parse template")
+	}
+
+	return tmpl, nil
+}
+
+// toString converts a template value to a string
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// isEmpty checks whether a template value is the zero value of its type or an empty collection
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
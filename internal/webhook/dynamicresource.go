@@ -0,0 +1,349 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook implements the admission webhooks of the DynamicResource API
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+	"github.com/tiegs/k8s-dynamic-resources/internal/transform"
+)
+
+// log is for logging in this package.
+var dynamicresourcelog = logf.Log.WithName("dynamicresource-resource")
+
+// DynamicResourceDefaulter makes the implicit defaults of a DynamicResource explicit in its spec
+type DynamicResourceDefaulter struct {
+	Mapper meta.RESTMapper
+}
+
+var _ admission.CustomDefaulter = &DynamicResourceDefaulter{}
+
+// Default implements admission.CustomDefaulter
+func (d *DynamicResourceDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*dynamickubev1alpha1.DynamicResource)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a DynamicResource but got %T", obj))
+	}
+
+	dynamicresourcelog.Info("default", "name", r.Name)
+
+	if r.Spec.ApplyStrategy == "" {
+		r.Spec.ApplyStrategy = dynamickubev1alpha1.ApplyStrategyUpdate
+	}
+
+	if r.Spec.ResyncInterval == nil {
+		r.Spec.ResyncInterval = &metav1.Duration{Duration: dynamickubev1alpha1.DefaultResyncInterval}
+	}
+
+	if r.Spec.Target != nil {
+		d.defaultTarget(r, r.Spec.Target)
+	}
+	for i := range r.Spec.Targets {
+		d.defaultTarget(r, &r.Spec.Targets[i].Unstructured)
+	}
+
+	for i := range r.Spec.Transformations {
+		trans := &r.Spec.Transformations[i]
+
+		if trans.FieldFrom != nil {
+			defaultFieldRef(trans.FieldFrom)
+		}
+		for name, ref := range trans.Sources {
+			defaultFieldRef(&ref)
+			trans.Sources[name] = ref
+		}
+	}
+
+	return nil
+}
+
+// defaultTarget places namespaced targets without namespace in the namespace of the DynamicResource.
+// Targets of unknown kinds are left as they are, they are rejected by the validating webhook.
+func (d *DynamicResourceDefaulter) defaultTarget(r *dynamickubev1alpha1.DynamicResource, target *unstructured.Unstructured) {
+	if target.GetNamespace() != "" {
+		return
+	}
+
+	gvk := target.GroupVersionKind()
+	mapping, err := d.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil || mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return
+	}
+
+	target.SetNamespace(r.Namespace)
+}
+
+// defaultFieldRef sets the apiVersion of core kinds and normalizes the FieldSpec to the {...} form
+func defaultFieldRef(ref *dynamickubev1alpha1.ExternalFieldRef) {
+	if ref.APIVersion == "" && (ref.Kind == "Secret" || ref.Kind == "ConfigMap") {
+		ref.APIVersion = "v1"
+	}
+
+	if fieldSpec, err := transform.NormalizeFieldSpec(ref.FieldSpec); err == nil {
+		ref.FieldSpec = fieldSpec
+	}
+}
+
+// DynamicResourceValidator rejects DynamicResources that would fail to reconcile because of an invalid spec
+type DynamicResourceValidator struct {
+	Mapper meta.RESTMapper
+}
+
+var _ admission.CustomValidator = &DynamicResourceValidator{}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *DynamicResourceValidator) ValidateCreate(_ context.Context, obj runtime.Object) error {
+	return v.validate(obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *DynamicResourceValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) error {
+	return v.validate(newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *DynamicResourceValidator) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
+
+// validate checks the spec of a DynamicResource
+func (v *DynamicResourceValidator) validate(obj runtime.Object) error {
+	r, ok := obj.(*dynamickubev1alpha1.DynamicResource)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a DynamicResource but got %T", obj))
+	}
+
+	dynamicresourcelog.Info("validate", "name", r.Name)
+
+	// Objects being deleted only need to be finalized
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	specPath := field.NewPath("spec")
+	allErrs, targetNames := v.validateTargets(&r.Spec, specPath)
+
+	for i, trans := range r.Spec.Transformations {
+		allErrs = append(allErrs, v.validateTransformation(trans, targetNames, specPath.Child("transformations").Index(i))...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(dynamickubev1alpha1.GroupVersion.WithKind("DynamicResource").GroupKind(), r.Name, allErrs)
+}
+
+// validateTargets checks the target definitions and returns the names of the targets
+func (v *DynamicResourceValidator) validateTargets(spec *dynamickubev1alpha1.DynamicResourceSpec, specPath *field.Path) (field.ErrorList, []string) {
+	var allErrs field.ErrorList
+	var names []string
+
+	switch {
+	case spec.Target != nil && len(spec.Targets) > 0:
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("targets"), "target and targets are mutually exclusive"))
+
+	case spec.Target != nil:
+		allErrs = append(allErrs, v.validateTarget(spec.Target, specPath.Child("target"))...)
+		names = append(names, spec.Target.GetName())
+
+	case len(spec.Targets) > 0:
+		for i := range spec.Targets {
+			allErrs = append(allErrs, v.validateTarget(&spec.Targets[i].Unstructured, specPath.Child("targets").Index(i))...)
+			names = append(names, spec.Targets[i].GetName())
+		}
+
+	default:
+		allErrs = append(allErrs, field.Required(specPath.Child("target"), "one of target or targets is required"))
+	}
+
+	return allErrs, names
+}
+
+// validateTarget checks that a target definition identifies an object of a known kind
+func (v *DynamicResourceValidator) validateTarget(target *unstructured.Unstructured, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if target.GetAPIVersion() == "" {
+		allErrs = append(allErrs, field.Required(path.Child("apiVersion"), ""))
+	}
+	if target.GetKind() == "" {
+		allErrs = append(allErrs, field.Required(path.Child("kind"), ""))
+	}
+	if target.GetName() == "" {
+		allErrs = append(allErrs, field.Required(path.Child("metadata", "name"), ""))
+	}
+
+	if len(allErrs) == 0 {
+		allErrs = append(allErrs, v.validateKind(target.GroupVersionKind(), path)...)
+	}
+
+	return allErrs
+}
+
+// validateTransformation checks the sources, FieldSpecs and target of a transformation
+func (v *DynamicResourceValidator) validateTransformation(trans dynamickubev1alpha1.DynamicResourceTransformation, targetNames []string, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch {
+	case trans.FieldFrom != nil && trans.Template != "":
+		allErrs = append(allErrs, field.Forbidden(path.Child("template"), "fieldFrom and template are mutually exclusive"))
+
+	case trans.CEL != "" && (trans.FieldFrom != nil || trans.Template != ""):
+		allErrs = append(allErrs, field.Forbidden(path.Child("cel"), "cel is mutually exclusive with fieldFrom and template"))
+
+	case trans.FieldFrom != nil:
+		if len(trans.Sources) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("sources"), "sources can only be used with template or cel"))
+		}
+		if trans.FieldFrom.ValueType != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("fieldFrom", "valueType"), "the value type of fieldFrom is set by the valueType of the transformation"))
+		}
+		allErrs = append(allErrs, v.validateFieldRef(*trans.FieldFrom, path.Child("fieldFrom"))...)
+
+	case trans.Template != "":
+		for name, ref := range trans.Sources {
+			allErrs = append(allErrs, v.validateFieldRef(ref, path.Child("sources").Key(name))...)
+		}
+		if _, err := transform.ParseTemplate(trans.Template); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("template"), trans.Template, err.Error()))
+		}
+
+	case trans.CEL != "":
+		for name, ref := range trans.Sources {
+			allErrs = append(allErrs, v.validateFieldRef(ref, path.Child("sources").Key(name))...)
+		}
+		if _, err := transform.CompileCEL(trans.CEL, trans.Sources); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("cel"), trans.CEL, err.Error()))
+		}
+
+	default:
+		allErrs = append(allErrs, field.Required(path.Child("fieldFrom"), "one of fieldFrom, template or cel is required"))
+	}
+
+	switch {
+	case trans.TargetField != "" && trans.TargetPath != "":
+		allErrs = append(allErrs, field.Forbidden(path.Child("targetPath"), "targetField and targetPath are mutually exclusive"))
+	case trans.TargetField == "" && trans.TargetPath == "":
+		allErrs = append(allErrs, field.Required(path.Child("targetField"), "one of targetField or targetPath is required"))
+	case trans.TargetPath != "":
+		if _, err := transform.ParseTargetPath(trans.TargetPath); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("targetPath"), trans.TargetPath, err.Error()))
+		}
+	}
+
+	if trans.Target != nil && len(targetNames) > 0 {
+		allErrs = append(allErrs, validateTargetSelector(*trans.Target, targetNames, path.Child("target"))...)
+	}
+
+	return allErrs
+}
+
+// validateFieldRef checks that a source reference selects objects of a known kind and has a valid FieldSpec
+func (v *DynamicResourceValidator) validateFieldRef(ref dynamickubev1alpha1.ExternalFieldRef, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if ref.APIVersion == "" {
+		allErrs = append(allErrs, field.Required(path.Child("apiVersion"), ""))
+	}
+	if ref.Kind == "" {
+		allErrs = append(allErrs, field.Required(path.Child("kind"), ""))
+	}
+	if len(allErrs) == 0 {
+		allErrs = append(allErrs, v.validateKind(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind), path)...)
+	}
+
+	hasSelector := ref.Selector != nil || ref.FieldSelector != ""
+	switch {
+	case ref.Name != "" && hasSelector:
+		allErrs = append(allErrs, field.Forbidden(path.Child("name"), "name is mutually exclusive with selector and fieldSelector"))
+	case ref.Name == "" && !hasSelector:
+		allErrs = append(allErrs, field.Required(path.Child("name"), "one of name, selector or fieldSelector is required"))
+	case hasSelector && ref.Pick == "":
+		allErrs = append(allErrs, field.Required(path.Child("pick"), "pick is required when using selector or fieldSelector"))
+	}
+
+	if ref.Selector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(ref.Selector); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("selector"), ref.Selector, err.Error()))
+		}
+	}
+
+	if ref.FieldSelector != "" {
+		if _, err := fields.ParseSelector(ref.FieldSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("fieldSelector"), ref.FieldSelector, err.Error()))
+		}
+	}
+
+	if ref.FieldSpec == "" {
+		allErrs = append(allErrs, field.Required(path.Child("fieldSpec"), ""))
+	} else if _, err := transform.ParseFieldSpec(ref.FieldSpec); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("fieldSpec"), ref.FieldSpec, err.Error()))
+	}
+
+	return allErrs
+}
+
+// validateKind checks that the kind is known to the API server
+func (v *DynamicResourceValidator) validateKind(gvk schema.GroupVersionKind, path *field.Path) field.ErrorList {
+	if _, err := v.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		return field.ErrorList{field.Invalid(path.Child("kind"), gvk.String(), err.Error())}
+	}
+
+	return nil
+}
+
+// validateTargetSelector checks that a transformation addresses an existing target by index or name
+func validateTargetSelector(selector intstr.IntOrString, targetNames []string, path *field.Path) field.ErrorList {
+	if selector.Type == intstr.Int {
+		if index := selector.IntValue(); index < 0 || index >= len(targetNames) {
+			return field.ErrorList{field.Invalid(path, index, "target index is out of range")}
+		}
+		return nil
+	}
+
+	matches := 0
+	for _, name := range targetNames {
+		if name == selector.StrVal {
+			matches++
+		}
+	}
+
+	switch {
+	case matches == 0:
+		return field.ErrorList{field.NotFound(path, selector.StrVal)}
+	case matches > 1:
+		return field.ErrorList{field.Invalid(path, selector.StrVal, "target name is ambiguous, use the target index instead")}
+	}
+
+	return nil
+}
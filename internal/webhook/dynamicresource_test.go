@@ -0,0 +1,426 @@
+/*
+Copyright 2022 Tilman Eggers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
+)
+
+// newTestRESTMapper returns a static RESTMapper for namespaced Secrets and ConfigMaps and cluster-scoped ClusterRoles
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+
+	return mapper
+}
+
+// testTarget returns a target definition of the kind
+func testTarget(apiVersion, kind, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+	}
+}
+
+// secretRef returns a reference to a field of the named Secret
+func secretRef(name, fieldSpec string) *dynamickubev1alpha1.ExternalFieldRef {
+	return &dynamickubev1alpha1.ExternalFieldRef{
+		TypeMeta:  metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		Name:      name,
+		FieldSpec: fieldSpec,
+	}
+}
+
+// typedSecretRef returns a reference to a field of the named Secret with the value type
+func typedSecretRef(name, fieldSpec string, valueType dynamickubev1alpha1.ValueType) *dynamickubev1alpha1.ExternalFieldRef {
+	ref := secretRef(name, fieldSpec)
+	ref.ValueType = valueType
+	return ref
+}
+
+// testDynamicResource returns a DynamicResource writing a ConfigMap with the transformations
+func testDynamicResource(transformations ...dynamickubev1alpha1.DynamicResourceTransformation) *dynamickubev1alpha1.DynamicResource {
+	return &dynamickubev1alpha1.DynamicResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+		Spec: dynamickubev1alpha1.DynamicResourceSpec{
+			Target:          &unstructured.Unstructured{Object: testTarget("v1", "ConfigMap", "target")},
+			Transformations: transformations,
+		},
+	}
+}
+
+// invalidFields returns the sorted paths of the fields an Invalid error reports
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) || statusErr.ErrStatus.Details == nil {
+		t.Fatalf("expected an Invalid error, got %v", err)
+	}
+
+	var paths []string
+	for _, cause := range statusErr.ErrStatus.Details.Causes {
+		paths = append(paths, cause.Field)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestValidate(t *testing.T) {
+	selectorRef := secretRef("", "{.data.password}")
+	selectorRef.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+
+	unknownKind := secretRef("db", "{.data.password}")
+	unknownKind.Kind = "Unknown"
+
+	tests := []struct {
+		name     string
+		obj      *dynamickubev1alpha1.DynamicResource
+		expected []string
+	}{
+		{
+			name: "fieldFrom",
+			obj:  testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"}),
+		},
+		{
+			name: "template with targetPath",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:    map[string]dynamickubev1alpha1.ExternalFieldRef{"user": *secretRef("db", "{.data.user}")},
+				Template:   `{{ .user | b64dec | default "admin" }}`,
+				TargetPath: "/data/app.kubernetes.io~1user",
+			}),
+		},
+		{
+			name: "cel",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"user": *secretRef("db", "{.data.user}")},
+				CEL:         `user + "@db"`,
+				TargetField: "data.user",
+			}),
+		},
+		{
+			name:     "targetPath without leading slash",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretRef("db", "{.data.password}"), TargetPath: "data/password"}),
+			expected: []string{"spec.transformations[0].targetPath"},
+		},
+		{
+			name: "unparsable template",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"user": *secretRef("db", "{.data.user}")},
+				Template:    `{{ .user | unknown }}`,
+				TargetField: "data.user",
+			}),
+			expected: []string{"spec.transformations[0].template"},
+		},
+		{
+			name: "unclosed template action",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Template:    `{{ .user `,
+				TargetField: "data.user",
+			}),
+			expected: []string{"spec.transformations[0].template"},
+		},
+		{
+			name: "invalid cel",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"user": *secretRef("db", "{.data.user}")},
+				CEL:         `password + "@db"`,
+				TargetField: "data.user",
+			}),
+			expected: []string{"spec.transformations[0].cel"},
+		},
+		{
+			name: "fieldFrom and template",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				FieldFrom:   secretRef("db", "{.data.password}"),
+				Template:    "static",
+				TargetField: "data.password",
+			}),
+			expected: []string{"spec.transformations[0].template"},
+		},
+		{
+			name: "fieldFrom with sources",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				FieldFrom:   secretRef("db", "{.data.password}"),
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"user": *secretRef("db", "{.data.user}")},
+				TargetField: "data.password",
+			}),
+			expected: []string{"spec.transformations[0].sources"},
+		},
+		{
+			name: "fieldFrom with valueType",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				FieldFrom:   typedSecretRef("db", "{.data.port}", dynamickubev1alpha1.ValueTypeInt),
+				TargetField: "data.port",
+			}),
+			expected: []string{"spec.transformations[0].fieldFrom.valueType"},
+		},
+		{
+			name: "cel with mistyped source",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				Sources:     map[string]dynamickubev1alpha1.ExternalFieldRef{"port": *typedSecretRef("db", "{.data.port}", dynamickubev1alpha1.ValueTypeInt)},
+				CEL:         `port + "1"`,
+				TargetField: "data.port",
+			}),
+			expected: []string{"spec.transformations[0].cel"},
+		},
+		{
+			name:     "no source",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{TargetField: "data.password"}),
+			expected: []string{"spec.transformations[0].fieldFrom"},
+		},
+		{
+			name:     "no target field",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretRef("db", "{.data.password}")}),
+			expected: []string{"spec.transformations[0].targetField"},
+		},
+		{
+			name: "targetField and targetPath",
+			obj: testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+				FieldFrom:   secretRef("db", "{.data.password}"),
+				TargetField: "data.password",
+				TargetPath:  "/data/password",
+			}),
+			expected: []string{"spec.transformations[0].targetPath"},
+		},
+		{
+			name:     "invalid fieldSpec",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: secretRef("db", "{.data[}"), TargetField: "data.password"}),
+			expected: []string{"spec.transformations[0].fieldFrom.fieldSpec"},
+		},
+		{
+			name:     "unknown source kind",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: unknownKind, TargetField: "data.password"}),
+			expected: []string{"spec.transformations[0].fieldFrom.kind"},
+		},
+		{
+			name:     "selector without pick",
+			obj:      testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{FieldFrom: selectorRef, TargetField: "data.password"}),
+			expected: []string{"spec.transformations[0].fieldFrom.pick"},
+		},
+		{
+			name: "unknown target kind",
+			obj: &dynamickubev1alpha1.DynamicResource{
+				Spec: dynamickubev1alpha1.DynamicResourceSpec{
+					Target: &unstructured.Unstructured{Object: testTarget("example.com/v1", "Unknown", "target")},
+					Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+						{FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+					},
+				},
+			},
+			expected: []string{"spec.target.kind"},
+		},
+		{
+			name: "target without name",
+			obj: &dynamickubev1alpha1.DynamicResource{
+				Spec: dynamickubev1alpha1.DynamicResourceSpec{
+					Target: &unstructured.Unstructured{Object: testTarget("v1", "ConfigMap", "")},
+					Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+						{FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+					},
+				},
+			},
+			expected: []string{"spec.target.metadata.name"},
+		},
+		{
+			name: "no target",
+			obj: &dynamickubev1alpha1.DynamicResource{
+				Spec: dynamickubev1alpha1.DynamicResourceSpec{
+					Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+						{FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+					},
+				},
+			},
+			expected: []string{"spec.target"},
+		},
+		{
+			name: "target and targets",
+			obj: &dynamickubev1alpha1.DynamicResource{
+				Spec: dynamickubev1alpha1.DynamicResourceSpec{
+					Target:  &unstructured.Unstructured{Object: testTarget("v1", "ConfigMap", "target")},
+					Targets: []dynamickubev1alpha1.TargetObject{{Unstructured: unstructured.Unstructured{Object: testTarget("v1", "ConfigMap", "other")}}},
+					Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+						{FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+					},
+				},
+			},
+			expected: []string{"spec.targets"},
+		},
+		{
+			name: "target selectors",
+			obj: &dynamickubev1alpha1.DynamicResource{
+				Spec: dynamickubev1alpha1.DynamicResourceSpec{
+					Targets: []dynamickubev1alpha1.TargetObject{
+						{Unstructured: unstructured.Unstructured{Object: testTarget("v1", "ConfigMap", "first")}},
+						{Unstructured: unstructured.Unstructured{Object: testTarget("v1", "Secret", "first")}},
+						{Unstructured: unstructured.Unstructured{Object: testTarget("rbac.authorization.k8s.io/v1", "ClusterRole", "role")}},
+					},
+					Transformations: []dynamickubev1alpha1.DynamicResourceTransformation{
+						{Target: intstrPtr(intstr.FromInt(1)), FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+						{Target: intstrPtr(intstr.FromString("role")), FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+						{Target: intstrPtr(intstr.FromInt(3)), FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+						{Target: intstrPtr(intstr.FromString("first")), FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+						{Target: intstrPtr(intstr.FromString("missing")), FieldFrom: secretRef("db", "{.data.password}"), TargetField: "data.password"},
+					},
+				},
+			},
+			expected: []string{"spec.transformations[2].target", "spec.transformations[3].target", "spec.transformations[4].target"},
+		},
+	}
+
+	validator := &DynamicResourceValidator{Mapper: newTestRESTMapper()}
+
+	for _, test := range tests {
+		err := validator.ValidateCreate(context.Background(), test.obj)
+		if fields := invalidFields(t, err); !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected invalid fields %q, got %q (%v)", test.name, test.expected, fields, err)
+		}
+	}
+
+	// DynamicResources being deleted are not validated, so that they can be finalized
+	deleted := testDynamicResource()
+	deleted.Spec.Target = nil
+	now := metav1.Now()
+	deleted.DeletionTimestamp = &now
+	if err := validator.ValidateUpdate(context.Background(), deleted, deleted); err != nil {
+		t.Errorf("unexpected error for deleted DynamicResource: %v", err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defaulter := &DynamicResourceDefaulter{Mapper: newTestRESTMapper()}
+
+	withNamespace := func(target map[string]interface{}, namespace string) map[string]interface{} {
+		u := &unstructured.Unstructured{Object: target}
+		u.SetNamespace(namespace)
+		return u.Object
+	}
+
+	tests := []struct {
+		name     string
+		target   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "namespaced target",
+			target:   testTarget("v1", "ConfigMap", "target"),
+			expected: withNamespace(testTarget("v1", "ConfigMap", "target"), "default"),
+		},
+		{
+			name:     "namespaced target in other namespace",
+			target:   withNamespace(testTarget("v1", "Secret", "target"), "other"),
+			expected: withNamespace(testTarget("v1", "Secret", "target"), "other"),
+		},
+		{
+			name:     "cluster-scoped target",
+			target:   testTarget("rbac.authorization.k8s.io/v1", "ClusterRole", "target"),
+			expected: testTarget("rbac.authorization.k8s.io/v1", "ClusterRole", "target"),
+		},
+		{
+			name:     "unknown target kind",
+			target:   testTarget("example.com/v1", "Unknown", "target"),
+			expected: testTarget("example.com/v1", "Unknown", "target"),
+		},
+	}
+
+	for _, test := range tests {
+		// Targets are defaulted the same way in target and targets
+		obj := testDynamicResource()
+		obj.Spec.Target = &unstructured.Unstructured{Object: test.target}
+		obj.Spec.Targets = []dynamickubev1alpha1.TargetObject{{Unstructured: unstructured.Unstructured{Object: runtime.DeepCopyJSON(test.target)}}}
+
+		if err := defaulter.Default(context.Background(), obj); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(obj.Spec.Target.Object, test.expected) {
+			t.Errorf("%s: expected target %v, got %v", test.name, test.expected, obj.Spec.Target.Object)
+		}
+		if !reflect.DeepEqual(obj.Spec.Targets[0].Object, test.expected) {
+			t.Errorf("%s: expected targets[0] %v, got %v", test.name, test.expected, obj.Spec.Targets[0].Object)
+		}
+	}
+
+	// Spec and source references
+	obj := testDynamicResource(dynamickubev1alpha1.DynamicResourceTransformation{
+		FieldFrom: &dynamickubev1alpha1.ExternalFieldRef{TypeMeta: metav1.TypeMeta{Kind: "Secret"}, Name: "db", FieldSpec: ".data.password"},
+		Sources: map[string]dynamickubev1alpha1.ExternalFieldRef{
+			"config": {TypeMeta: metav1.TypeMeta{Kind: "ConfigMap"}, Name: "app", FieldSpec: "{.data.host}"},
+			"role":   {TypeMeta: metav1.TypeMeta{Kind: "ClusterRole"}, Name: "role", FieldSpec: ".rules"},
+		},
+	})
+	resync := metav1.Duration{Duration: 5 * dynamickubev1alpha1.DefaultResyncInterval}
+	obj.Spec.ResyncInterval = &resync
+
+	if err := defaulter.Default(context.Background(), obj); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if obj.Spec.ApplyStrategy != dynamickubev1alpha1.ApplyStrategyUpdate {
+		t.Errorf("expected applyStrategy %s, got %s", dynamickubev1alpha1.ApplyStrategyUpdate, obj.Spec.ApplyStrategy)
+	}
+	if obj.Spec.ResyncInterval.Duration != resync.Duration {
+		t.Errorf("expected resyncInterval to be kept, got %s", obj.Spec.ResyncInterval.Duration)
+	}
+
+	trans := obj.Spec.Transformations[0]
+	if trans.FieldFrom.APIVersion != "v1" || trans.FieldFrom.FieldSpec != "{.data.password}" {
+		t.Errorf("unexpected defaults of fieldFrom: %+v", trans.FieldFrom)
+	}
+	if ref := trans.Sources["config"]; ref.APIVersion != "v1" || ref.FieldSpec != "{.data.host}" {
+		t.Errorf("unexpected defaults of source 'config': %+v", ref)
+	}
+	// Only the apiVersion of core kinds is known
+	if ref := trans.Sources["role"]; ref.APIVersion != "" || ref.FieldSpec != "{.rules}" {
+		t.Errorf("unexpected defaults of source 'role': %+v", ref)
+	}
+
+	// Defaults of an empty spec
+	empty := &dynamickubev1alpha1.DynamicResource{}
+	if err := defaulter.Default(context.Background(), empty); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if empty.Spec.ResyncInterval == nil || empty.Spec.ResyncInterval.Duration != dynamickubev1alpha1.DefaultResyncInterval {
+		t.Errorf("expected default resyncInterval, got %v", empty.Spec.ResyncInterval)
+	}
+}
+
+func intstrPtr(value intstr.IntOrString) *intstr.IntOrString {
+	return &value
+}
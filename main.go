@@ -33,6 +33,7 @@ import (
 
 	dynamickubev1alpha1 "github.com/tiegs/k8s-dynamic-resources/api/v1alpha1"
 	"github.com/tiegs/k8s-dynamic-resources/controllers"
+	"github.com/tiegs/k8s-dynamic-resources/internal/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -52,11 +53,17 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var allowCrossNamespaceSources bool
+	var requireServiceAccount bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&allowCrossNamespaceSources, "allow-cross-namespace-sources", false,
+		"Allow DynamicResources to read fieldFrom sources from other namespaces.")
+	flag.BoolVar(&requireServiceAccount, "require-service-account", false,
+		"Require DynamicResources to specify a ServiceAccount that is impersonated to read sources and write targets.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,12 +86,24 @@ func main() {
 	}
 
 	if err = (&controllers.DynamicResourceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("dynamicresource-controller"),
+
+		AllowCrossNamespaceSources: allowCrossNamespaceSources,
+		RequireServiceAccount:      requireServiceAccount,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DynamicResource")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		defaulter := &webhook.DynamicResourceDefaulter{Mapper: mgr.GetRESTMapper()}
+		validator := &webhook.DynamicResourceValidator{Mapper: mgr.GetRESTMapper()}
+		if err = (&dynamickubev1alpha1.DynamicResource{}).SetupWebhookWithManager(mgr, defaulter, validator); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DynamicResource")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {